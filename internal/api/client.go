@@ -0,0 +1,164 @@
+// Package api is the client for the Laravel /savePicDataFromGo endpoint.
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ahMakerdir/internal/config"
+)
+
+// Item is one image entry sent to the Laravel API.
+type Item struct {
+	ExcelColD string `json:"excel_col_d"`
+	FtpPath   string `json:"ftp_path"`
+	Sort      int    `json:"sort"`
+	IsDef     int    `json:"is_def"`
+	ColorPic  string `json:"color_pic,omitempty"`
+}
+
+// Payload is the request body, keyed by filename.
+type Payload map[string]Item
+
+// Client posts payloads to the Laravel API.
+type Client struct {
+	URL        string
+	Key        string
+	Sign       bool          // Send an HMAC-SHA256 signature instead of the raw key
+	Retries    int           // Extra attempts on timeouts and 5xx responses
+	RetryDelay time.Duration // First retry delay, doubled after each attempt
+	HTTPClient *http.Client
+	Log        func(string)
+}
+
+// NewClient builds a client from the API settings in cfg.
+func NewClient(cfg config.Config, log func(string)) *Client {
+	delay := time.Duration(cfg.ApiRetryDelaySec) * time.Second
+	if delay <= 0 {
+		delay = 2 * time.Second
+	}
+	retries := cfg.ApiRetries
+	if retries < 0 {
+		retries = 0
+	}
+	return &Client{
+		URL:        cfg.ApiUrl,
+		Key:        cfg.ApiKey,
+		Sign:       cfg.ApiSignRequests,
+		Retries:    retries,
+		RetryDelay: delay,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second}, // Increased timeout for dd() which might be slow or large
+		Log:        log,
+	}
+}
+
+// Submit posts the payload, retrying timeouts and 5xx responses with
+// exponential backoff. Every attempt carries the same Idempotency-Key so the
+// backend can drop a batch it has already stored.
+//
+// A nil error means the batch was accepted. A refused batch returns *Error,
+// an unknown response version returns ErrUnsupportedVersion together with the
+// decoded response.
+func (c *Client) Submit(payload Payload) (*Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Map keys are marshalled in sorted order, so the same batch always
+	// yields the same key, also when it is sent again later.
+	sum := sha256.Sum256(body)
+	idempotencyKey := hex.EncodeToString(sum[:])
+
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		resp, retryable, err := c.post(body, idempotencyKey)
+		if err == nil || !retryable || attempt >= c.Retries {
+			return resp, err
+		}
+		c.logf("API attempt %d/%d failed: %v. Retrying in %s...", attempt+1, c.Retries+1, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes a single request. The bool result reports whether the failure
+// is worth retrying.
+func (c *Client) post(body []byte, idempotencyKey string) (*Response, bool, error) {
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+
+	if c.Key != "" {
+		if c.Sign {
+			// Sign instead of sending the raw key: HMAC-SHA256(key, timestamp + "." + body)
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set("X-Timestamp", timestamp)
+			req.Header.Set("X-Signature", Signature(c.Key, timestamp, body))
+		} else {
+			req.Header.Set("key", c.Key)
+		}
+	}
+
+	httpResp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		// Network errors and timeouts
+		return nil, true, err
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var resp Response
+	decodeErr := json.Unmarshal(respBody, &resp)
+
+	if httpResp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: httpResp.StatusCode, Body: string(respBody)}
+		if decodeErr == nil {
+			apiErr.Message = resp.Message
+			apiErr.Response = &resp
+		}
+		retryable := httpResp.StatusCode >= 500 || httpResp.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, apiErr
+	}
+
+	if decodeErr != nil {
+		return nil, false, fmt.Errorf("invalid API response: %v. Body: %s", decodeErr, respBody)
+	}
+	if err := resp.checkVersion(); err != nil {
+		return &resp, false, err
+	}
+	if resp.Failed() {
+		return nil, false, &Error{StatusCode: httpResp.StatusCode, Message: resp.Message, Body: string(respBody), Response: &resp}
+	}
+	return &resp, false, nil
+}
+
+// Signature returns the hex HMAC-SHA256 of timestamp + "." + body.
+func Signature(key, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.Log != nil {
+		c.Log(fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,125 @@
+package api_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"ahMakerdir/internal/api"
+	"ahMakerdir/internal/mockapi"
+)
+
+var testPayload = api.Payload{
+	"ITEM1_01.jpg": {ExcelColD: "ITEM1", FtpPath: "/image/GoodsColor/ITEM1_01.jpg", Sort: 1, IsDef: 1},
+	"ITEM2_01.jpg": {ExcelColD: "ITEM2", FtpPath: "/image/GoodsColor/ITEM2_01.jpg", Sort: 1, IsDef: 1},
+}
+
+func startMock(t *testing.T, script mockapi.Script) (*mockapi.Server, *api.Client) {
+	t.Helper()
+	srv := mockapi.New(script)
+	url, err := srv.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv, &api.Client{URL: url, RetryDelay: 20 * time.Millisecond, HTTPClient: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func TestSubmitRetriesWithBackoff(t *testing.T) {
+	srv, c := startMock(t, mockapi.Script{FailFirst: 2})
+	c.Retries = 3
+	if _, err := c.Submit(testPayload); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	requests := srv.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("mock received %d requests, want 2 failures and 1 success", len(requests))
+	}
+	// 20ms before the first retry, doubled before the second
+	for i, min := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if gap := requests[i+1].Time.Sub(requests[i].Time); gap < min {
+			t.Errorf("retry %d after %s, want at least %s", i+1, gap, min)
+		}
+	}
+
+	body, _ := json.Marshal(testPayload)
+	sum := sha256.Sum256(body)
+	for i, r := range requests {
+		if r.IdempotencyKey != hex.EncodeToString(sum[:]) {
+			t.Errorf("request %d: Idempotency-Key %q, want the SHA-256 of the body", i+1, r.IdempotencyKey)
+		}
+	}
+}
+
+func TestSubmitGivesUpAfterRetries(t *testing.T) {
+	srv, c := startMock(t, mockapi.Script{FailFirst: 5})
+	c.Retries = 1
+	_, err := c.Submit(testPayload)
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Message != "scripted failure" {
+		t.Errorf("Submit err = %v, want the scripted HTTP 500", err)
+	}
+	if got := len(srv.Requests()); got != 2 {
+		t.Errorf("mock received %d requests, want 2", got)
+	}
+}
+
+func TestSubmitIdempotencyKey(t *testing.T) {
+	srv, c := startMock(t, mockapi.Script{})
+	other := api.Payload{"ITEM1_01.jpg": testPayload["ITEM1_01.jpg"]}
+	for _, p := range []api.Payload{testPayload, testPayload, other} {
+		if _, err := c.Submit(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := srv.Requests()
+	if r[0].IdempotencyKey == "" || r[0].IdempotencyKey != r[1].IdempotencyKey {
+		t.Errorf("the same payload got keys %q and %q", r[0].IdempotencyKey, r[1].IdempotencyKey)
+	}
+	if r[2].IdempotencyKey == r[0].IdempotencyKey {
+		t.Error("a different payload got the same key")
+	}
+}
+
+func TestSubmitKeyAndSignature(t *testing.T) {
+	for _, tc := range []struct {
+		key  string
+		sign bool
+		ok   bool
+	}{
+		{"secret", false, true},
+		{"secret", true, true},
+		{"wrong", false, false},
+		{"wrong", true, false},
+	} {
+		srv, c := startMock(t, mockapi.Script{Key: "secret"})
+		c.Key, c.Sign, c.Retries = tc.key, tc.sign, 2
+		_, err := c.Submit(testPayload)
+		if tc.ok != (err == nil) {
+			t.Errorf("key %q, sign %v: err = %v", tc.key, tc.sign, err)
+		}
+
+		requests := srv.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("key %q, sign %v: %d requests, a refused key is not retried", tc.key, tc.sign, len(requests))
+		}
+		r := requests[0]
+		if tc.sign && (r.Key != "" || r.Timestamp == "" || r.Signature == "") {
+			t.Errorf("signed request sent key %q, timestamp %q, signature %q", r.Key, r.Timestamp, r.Signature)
+		}
+		if !tc.sign && (r.Key != tc.key || r.Signature != "") {
+			t.Errorf("unsigned request sent key %q, signature %q", r.Key, r.Signature)
+		}
+		if tc.sign && tc.ok {
+			body, _ := json.Marshal(testPayload)
+			if want := api.Signature("secret", r.Timestamp, body); r.Signature != want {
+				t.Errorf("signature %s, want %s", r.Signature, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SupportedVersions lists the response schema versions this client understands.
+// Responses without a version field are the original format and count as "1".
+var SupportedVersions = []string{"1"}
+
+// ErrUnsupportedVersion is returned when the response declares a schema
+// version this client does not know. The batch may have been stored anyway.
+var ErrUnsupportedVersion = errors.New("unsupported API response schema version")
+
+// Response is the decoded reply of /savePicDataFromGo.
+type Response struct {
+	Version                 string      `json:"version,omitempty"`
+	Status                  string      `json:"status"`
+	Message                 string      `json:"message,omitempty"`
+	NotFoundSNs             []string    `json:"not_found_sns,omitempty"`
+	SuccessGoodsColorPicIDs []int       `json:"success_goods_color_pic_ids,omitempty"`
+	SuccessGoodsColorIDs    []int       `json:"success_goods_color_ids,omitempty"`
+	Errors                  []ItemError `json:"errors,omitempty"`
+}
+
+// ItemError is a problem the backend reports for a single image or SN.
+type ItemError struct {
+	SN      string `json:"sn,omitempty"`
+	FtpPath string `json:"ftp_path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ItemError) String() string {
+	var ref []string
+	if e.SN != "" {
+		ref = append(ref, e.SN)
+	}
+	if e.FtpPath != "" {
+		ref = append(ref, e.FtpPath)
+	}
+	if len(ref) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(ref, " "), e.Message)
+}
+
+// UnmarshalJSON accepts both "message" and the legacy "massage" key.
+func (r *Response) UnmarshalJSON(data []byte) error {
+	type plain Response
+	var raw struct {
+		plain
+		Version json.RawMessage `json:"version"`
+		Massage string          `json:"massage"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = Response(raw.plain)
+	if r.Message == "" {
+		r.Message = raw.Massage
+	}
+	// The version may be sent as a number or a string
+	if len(raw.Version) > 0 && string(raw.Version) != "null" {
+		r.Version = strings.Trim(string(raw.Version), `"`)
+	}
+	return nil
+}
+
+// Failed reports whether the backend refused the batch even with HTTP 200.
+func (r *Response) Failed() bool {
+	switch strings.ToLower(r.Status) {
+	case "error", "fail", "failed":
+		return true
+	}
+	return false
+}
+
+// checkVersion returns ErrUnsupportedVersion for unknown schema versions.
+func (r *Response) checkVersion() error {
+	if r.Version == "" {
+		return nil
+	}
+	for _, v := range SupportedVersions {
+		if r.Version == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q (supported: %s)", ErrUnsupportedVersion, r.Version, strings.Join(SupportedVersions, ", "))
+}
+
+// Merge appends the results of another chunk.
+func (r *Response) Merge(other *Response) {
+	r.NotFoundSNs = append(r.NotFoundSNs, other.NotFoundSNs...)
+	r.SuccessGoodsColorPicIDs = append(r.SuccessGoodsColorPicIDs, other.SuccessGoodsColorPicIDs...)
+	r.SuccessGoodsColorIDs = append(r.SuccessGoodsColorIDs, other.SuccessGoodsColorIDs...)
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+// Error describes a failed call: an HTTP error status or a response whose
+// status marks the batch as refused. Response is set when the body could be decoded.
+type Error struct {
+	StatusCode int
+	Message    string
+	Body       string
+	Response   *Response
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("API error (HTTP %d): %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API returned status %d. Body: %s", e.StatusCode, e.Body)
+}
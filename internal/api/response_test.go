@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseUnmarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		body             string
+		message, version string
+	}{
+		{`{"status":"success","message":"ok"}`, "ok", ""},
+		{`{"status":"error","massage":"invalid key"}`, "invalid key", ""},
+		{`{"status":"error","message":"new","massage":"old"}`, "new", ""},
+		{`{"status":"success","version":1}`, "", "1"},
+		{`{"status":"success","version":"2"}`, "", "2"},
+		{`{"status":"success","version":null}`, "", ""},
+	} {
+		var resp Response
+		if err := json.Unmarshal([]byte(tc.body), &resp); err != nil {
+			t.Errorf("%s: %v", tc.body, err)
+			continue
+		}
+		if resp.Message != tc.message || resp.Version != tc.version {
+			t.Errorf("%s: message %q, version %q, want %q, %q", tc.body, resp.Message, resp.Version, tc.message, tc.version)
+		}
+	}
+}
+
+func TestResponseFailed(t *testing.T) {
+	for status, want := range map[string]bool{
+		"error":   true,
+		"fail":    true,
+		"failed":  true,
+		"FAILED":  true,
+		"success": false,
+		"":        false,
+	} {
+		if got := (&Response{Status: status}).Failed(); got != want {
+			t.Errorf("Failed() with status %q = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestSubmitUnsupportedVersion(t *testing.T) {
+	for _, tc := range []struct {
+		body        string
+		unsupported bool
+	}{
+		{`{"status":"success"}`, false},
+		{`{"status":"success","version":1}`, false},
+		{`{"status":"success","version":"1"}`, false},
+		{`{"status":"success","version":2,"success_goods_color_ids":[7]}`, true},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(tc.body))
+		}))
+		c := &Client{URL: srv.URL, HTTPClient: srv.Client()}
+		resp, err := c.Submit(Payload{"ITEM1_01.jpg": {ExcelColD: "ITEM1"}})
+		srv.Close()
+
+		if got := errors.Is(err, ErrUnsupportedVersion); got != tc.unsupported {
+			t.Errorf("%s: err = %v, want unsupported version %v", tc.body, err, tc.unsupported)
+		}
+		if tc.unsupported && (resp == nil || len(resp.SuccessGoodsColorIDs) != 1) {
+			t.Errorf("%s: decoded response not returned with the error: %+v", tc.body, resp)
+		}
+		if !tc.unsupported && err != nil {
+			t.Errorf("%s: %v", tc.body, err)
+		}
+	}
+}
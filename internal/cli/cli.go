@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"ahMakerdir/internal/config"
+	"ahMakerdir/internal/logic"
+)
+
+const usage = `Usage: ahMakerdir [command] [arguments]
+
+Without a command the GUI is started.
+
+Commands:
+  validate                Check the Excel sheet in WorkPath; exits 1 when it has errors
+  replay <payload.json>   Re-submit a payload saved in ApiResults to the Laravel API
+  mock-api [flags]        Run a local stand-in for the Laravel API (see mock-api -h)
+`
+
+// Run executes a command line subcommand and returns the process exit code.
+func Run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	log := func(msg string) {
+		fmt.Println(msg)
+	}
+
+	var err error
+	switch args[0] {
+	case "validate":
+		var issues []logic.ValidationIssue
+		issues, err = logic.ValidateExcel(loadConfig(), log)
+		if logic.CountErrors(issues) > 0 {
+			return 1
+		}
+	case "replay":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return 2
+		}
+		err = logic.RunReplay(loadConfig(), args[1], log)
+	case "mock-api":
+		err = runMockAPI(args[1:], log)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// loadConfig reads config.json next to the executable, like the GUI does.
+func loadConfig() config.Config {
+	cfg, err := config.Load(config.GetConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to load config: %v\n", err)
+	}
+	return cfg
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"ahMakerdir/internal/mockapi"
+)
+
+// runMockAPI serves the mock Laravel API until interrupted.
+func runMockAPI(args []string, log func(string)) error {
+	fs := flag.NewFlagSet("mock-api", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8089", "listen address")
+	notFound := fs.String("not-found", "", "comma separated SNs to report in not_found_sns")
+	var script mockapi.Script
+	fs.IntVar(&script.FailFirst, "fail-first", 0, "answer the first N requests with HTTP 500")
+	fs.IntVar(&script.HTTPStatus, "http-status", 0, "answer every request with this HTTP status")
+	fs.StringVar(&script.Status, "status", "success", `value of "status" in the response`)
+	fs.StringVar(&script.Message, "message", "", `value of "message" in the response`)
+	fs.StringVar(&script.Version, "version", "", `value of "version" in the response`)
+	fs.DurationVar(&script.Delay, "delay", 0, "wait before answering, e.g. 45s")
+	fs.StringVar(&script.Key, "key", "", "require this API key (plain or signed)")
+	fs.StringVar(&script.RecordDir, "record", "", "save received payloads to this directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, sn := range strings.Split(*notFound, ",") {
+		if sn = strings.TrimSpace(sn); sn != "" {
+			script.NotFoundSNs = append(script.NotFoundSNs, sn)
+		}
+	}
+
+	srv := mockapi.New(script)
+	srv.Log = log
+	url, err := srv.Start(*addr)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	log(fmt.Sprintf("Mock API listening on %s (set ApiUrl to this URL). Ctrl+C to stop.", url))
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+	log(fmt.Sprintf("Stopped after %d requests.", len(srv.Requests())))
+	return nil
+}
@@ -9,7 +9,9 @@ import (
 // Config represents the application configuration
 type Config struct {
 	WorkPath       string `json:"WorkPath"`
+	InputFile      string `json:"InputFile"` // .xlsx, .xlsm, .csv or .tsv, relative to WorkPath; empty finds the only one
 	PictureDirName string `json:"PictureDirName"`
+	Sheets         string `json:"Sheets"` // Sheet names or 1-based indexes, comma separated, name=dir for its own picture dir; empty uses the active sheet
 	SizeTablePath  string `json:"SizeTablePath"`
 	ColorPicPath   string `json:"ColorPicPath"`
 	Width          string `json:"width"`  // Keeping as string to match original JSON, but logic might need int
@@ -21,6 +23,34 @@ type Config struct {
 	FtpPort        string `json:"FtpPort"`
 	FtpUser        string `json:"FtpUser"`
 	FtpPassword    string `json:"FtpPassword"`
+	VerifyUpload   bool   `json:"VerifyUpload"`  // Compare remote size/checksum after upload
+	VerifyRetries  int    `json:"VerifyRetries"` // Re-upload attempts for mismatched files, 0 for none
+
+	RemotePathTemplate    string `json:"RemotePathTemplate"`    // e.g. GoodsColor/{date}/{item}/{filename}
+	RemoteCollisionPolicy string `json:"RemoteCollisionPolicy"` // overwrite, skip or rename
+	TransactionalUpload   bool   `json:"TransactionalUpload"`   // Remove this run's uploads when the API call fails
+	ApiChunkSize          int    `json:"ApiChunkSize"`          // Item codes per API request, 0 sends all at once
+	ApiRetries            int    `json:"ApiRetries"`            // Extra attempts on timeouts and 5xx responses
+	ApiRetryDelaySec      int    `json:"ApiRetryDelaySec"`      // First retry delay, doubled after each attempt
+	ApiSignRequests       bool   `json:"ApiSignRequests"`       // Send an HMAC-SHA256 signature instead of the raw ApiKey
+	ExcelWriteBack        bool   `json:"ExcelWriteBack"`        // Save a copy of the workbook with per-row status columns
+	SizeTableRules        string `json:"SizeTableRules"`        // Lookup order, e.g. style, prefix:5, sheet:SizeTables, code
+	SizeTableExtensions   string `json:"SizeTableExtensions"`   // Tried in order for every rule, e.g. .jpg,.png
+	SizeTableGenerate     bool   `json:"SizeTableGenerate"`     // Render missing size tables from SizeTableData
+	SizeTableData         string `json:"SizeTableData"`         // Measurements sheet name or .csv/.tsv file
+	SizeTableImageSize    string `json:"SizeTableImageSize"`    // e.g. 1000x1000
+	SizeTableFont         string `json:"SizeTableFont"`         // .ttf/.otf/.ttc, needed for Chinese text
+	SizeTableHeaderColor  string `json:"SizeTableHeaderColor"`  // Header background, e.g. #333333
+	SizeTableTextColor    string `json:"SizeTableTextColor"`    // Title and cell text, e.g. #333333
+	SwatchGenerate        string `json:"SwatchGenerate"`        // Color swatch for rows without one: "", sample or column
+	SwatchRegion          string `json:"SwatchRegion"`          // Sampled part of the default image, x,y,width,height as fractions
+	SwatchColorColumn     string `json:"SwatchColorColumn"`     // Column with a hex code or color name, e.g. G
+	SwatchSize            string `json:"SwatchSize"`            // e.g. 100x100
+	SourceIndexCache      string `json:"SourceIndexCache"`      // JSON file keeping the source folder listings between runs
+	SourceMirrorPath      string `json:"SourceMirrorPath"`      // Local copy of the color pics and size tables used
+	FilenameTemplate      string `json:"FilenameTemplate"`      // Split image names, e.g. {item}_{seq:2}{role:duplicate=_01}{ext}
+	DefaultImageRules     string `json:"DefaultImageRules"`     // is_def per image, first match wins, e.g. J=1, K=2, first=1
+	DuplicateRules        string `json:"DuplicateRules"`        // Extra copies of is_def images, e.g. copy=1 2, is_def=0, sort=after
 }
 
 // DefaultConfig returns a default configuration
@@ -38,12 +68,33 @@ func DefaultConfig() Config {
 		FtpPort:        "21",
 		FtpUser:        "user",
 		FtpPassword:    "pass",
+		VerifyUpload:   false,
+		VerifyRetries:  2,
+
+		RemotePathTemplate:    "GoodsColor/{date}/{filename}",
+		RemoteCollisionPolicy: "overwrite",
+		ApiChunkSize:          50,
+		ApiRetries:            3,
+		ApiRetryDelaySec:      2,
+		SizeTableRules:        "style",
+		SizeTableExtensions:   ".jpg,.png",
+		SizeTableImageSize:    "1000x1000",
+		SizeTableHeaderColor:  "#333333",
+		SizeTableTextColor:    "#333333",
+		SwatchRegion:          "0.4,0.4,0.2,0.2",
+		SwatchColorColumn:     "G",
+		SwatchSize:            "100x100",
+		FilenameTemplate:      "{item}_{seq:2}{role:duplicate=_01}{ext}",
+		DefaultImageRules:     "J=1, K=2",
+		DuplicateRules:        "copy=1 2, is_def=0, sort=after",
 	}
 }
 
-// Load reads the config from the given path
+// Load reads the config from the given path. Settings missing from the file
+// stay empty, except the API retry settings, which older files do not have.
 func Load(path string) (Config, error) {
-	var cfg Config
+	def := DefaultConfig()
+	cfg := Config{ApiRetries: def.ApiRetries, ApiRetryDelaySec: def.ApiRetryDelaySec}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return DefaultConfig(), err
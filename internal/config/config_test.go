@@ -0,0 +1,58 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOlderConfig(t *testing.T) {
+	// config.json as written before the API retry settings existed, without an API URL
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+    "WorkPath": "C:\\goImgTest",
+    "PictureDirName": "org",
+    "width": "500",
+    "height": "700",
+    "quality": 80,
+    "ApiKey": "",
+    "FtpPort": "21",
+    "FtpPassword": "",
+    "VerifyRetries": 0
+}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := DefaultConfig()
+	for _, tc := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"WorkPath", cfg.WorkPath, `C:\goImgTest`},
+		{"quality", cfg.Quality, 80},
+		{"ApiUrl", cfg.ApiUrl, ""}, // Empty skips the API call
+		{"FtpHost", cfg.FtpHost, ""},
+		{"FtpUser", cfg.FtpUser, ""},
+		{"VerifyRetries", cfg.VerifyRetries, 0},
+		{"ApiChunkSize", cfg.ApiChunkSize, 0},
+		{"ApiRetries", cfg.ApiRetries, def.ApiRetries},
+		{"ApiRetryDelaySec", cfg.ApiRetryDelaySec, def.ApiRetryDelaySec},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+
+	// Values in the file win, also when they are 0
+	if err := os.WriteFile(path, []byte(`{"ApiRetries": 0, "ApiRetryDelaySec": 5}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = Load(path); err != nil || cfg.ApiRetries != 0 || cfg.ApiRetryDelaySec != 5 {
+		t.Errorf("Load = ApiRetries %d, ApiRetryDelaySec %d, %v; want 0, 5", cfg.ApiRetries, cfg.ApiRetryDelaySec, err)
+	}
+}
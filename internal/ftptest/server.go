@@ -0,0 +1,382 @@
+// Package ftptest is a small in-memory FTP server for tests. It implements
+// the commands the upload code uses: login, FEAT, TYPE, PWD, CWD, MKD, EPSV,
+// PASV, STOR, RETR, NLST, SIZE, HASH, DELE and QUIT.
+package ftptest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server keeps all files in memory, keyed by absolute slash separated path.
+type Server struct {
+	User     string
+	Password string
+	NoHash   bool // Do not advertise the HASH command
+
+	// Corrupt, when set, may change the data of an uploaded file before it is stored.
+	Corrupt func(name string, data []byte) []byte
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	dirs     map[string]bool
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a free local port.
+func NewServer(user, password string) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		User:     user,
+		Password: password,
+		files:    make(map[string][]byte),
+		dirs:     map[string]bool{"/": true},
+		listener: l,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the host and port to dial.
+func (s *Server) Addr() (string, string) {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host, port
+}
+
+// Close stops accepting connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Files returns a copy of the stored files.
+func (s *Server) Files() map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string][]byte, len(s.files))
+	for name, data := range s.files {
+		out[name] = append([]byte(nil), data...)
+	}
+	return out
+}
+
+// Names returns the sorted paths of all stored files.
+func (s *Server) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PutFile stores a file directly, creating its directories.
+func (s *Server) PutFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = path.Clean("/" + name)
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		s.dirs[dir] = true
+	}
+	s.files[name] = append([]byte(nil), data...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.session(conn)
+		}()
+	}
+}
+
+type session struct {
+	s        *Server
+	conn     net.Conn
+	r        *bufio.Reader
+	cwd      string
+	user     string
+	loggedIn bool
+	pasv     net.Listener
+}
+
+func (s *Server) session(conn net.Conn) {
+	defer conn.Close()
+	ss := &session{s: s, conn: conn, r: bufio.NewReader(conn), cwd: "/"}
+	defer ss.closePasv()
+
+	ss.reply(220, "ftptest ready")
+	for {
+		line, err := ss.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		if !ss.handle(strings.ToUpper(verb), arg) {
+			return
+		}
+	}
+}
+
+func (ss *session) reply(code int, msg string) {
+	fmt.Fprintf(ss.conn, "%d %s\r\n", code, msg)
+}
+
+// handle runs one command and reports whether the session continues.
+func (ss *session) handle(verb, arg string) bool {
+	switch verb {
+	case "USER":
+		ss.user = arg
+		ss.reply(331, "password required")
+		return true
+	case "PASS":
+		if ss.user == ss.s.User && arg == ss.s.Password {
+			ss.loggedIn = true
+			ss.reply(230, "logged in")
+		} else {
+			ss.reply(530, "login incorrect")
+		}
+		return true
+	case "QUIT":
+		ss.reply(221, "bye")
+		return false
+	case "NOOP":
+		ss.reply(200, "ok")
+		return true
+	}
+
+	if !ss.loggedIn {
+		ss.reply(530, "not logged in")
+		return true
+	}
+
+	switch verb {
+	case "FEAT":
+		feat := "211-Features:\r\n SIZE\r\n EPSV\r\n PASV\r\n"
+		if !ss.s.NoHash {
+			feat += " HASH SHA-256*\r\n"
+		}
+		fmt.Fprintf(ss.conn, "%s211 End\r\n", feat)
+	case "TYPE":
+		ss.reply(200, "type set")
+	case "OPTS":
+		ss.reply(501, "option not supported")
+	case "PWD":
+		ss.reply(257, fmt.Sprintf("%q is the current directory", ss.cwd))
+	case "CWD":
+		dir := ss.abs(arg)
+		if ss.s.isDir(dir) {
+			ss.cwd = dir
+			ss.reply(250, "directory changed")
+		} else {
+			ss.reply(550, "no such directory")
+		}
+	case "MKD":
+		dir := ss.abs(arg)
+		ss.s.mu.Lock()
+		exists := ss.s.dirs[dir]
+		parentOK := ss.s.dirs[path.Dir(dir)]
+		if !exists && parentOK {
+			ss.s.dirs[dir] = true
+		}
+		ss.s.mu.Unlock()
+		if exists || !parentOK {
+			ss.reply(550, "cannot create directory")
+		} else {
+			ss.reply(257, fmt.Sprintf("%q created", dir))
+		}
+	case "EPSV":
+		if port, err := ss.openPasv(); err == nil {
+			ss.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		} else {
+			ss.reply(425, err.Error())
+		}
+	case "PASV":
+		if port, err := ss.openPasv(); err == nil {
+			ss.reply(227, fmt.Sprintf("Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256))
+		} else {
+			ss.reply(425, err.Error())
+		}
+	case "STOR":
+		name := ss.abs(arg)
+		if !ss.s.isDir(path.Dir(name)) {
+			ss.closePasv()
+			ss.reply(553, "no such directory")
+			break
+		}
+		ss.transfer(func(data net.Conn) error {
+			content, err := io.ReadAll(data)
+			if err != nil {
+				return err
+			}
+			if ss.s.Corrupt != nil {
+				content = ss.s.Corrupt(name, content)
+			}
+			ss.s.mu.Lock()
+			ss.s.files[name] = content
+			ss.s.mu.Unlock()
+			return nil
+		})
+	case "RETR":
+		content, ok := ss.s.file(ss.abs(arg))
+		if !ok {
+			ss.closePasv()
+			ss.reply(550, "no such file")
+			break
+		}
+		ss.transfer(func(data net.Conn) error {
+			_, err := data.Write(content)
+			return err
+		})
+	case "NLST":
+		dir := ss.cwd
+		if arg != "" {
+			dir = ss.abs(arg)
+		}
+		if !ss.s.isDir(dir) {
+			ss.closePasv()
+			ss.reply(550, "no such directory")
+			break
+		}
+		names := ss.s.list(dir)
+		ss.transfer(func(data net.Conn) error {
+			for _, name := range names {
+				if _, err := fmt.Fprintf(data, "%s\r\n", name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "SIZE":
+		if content, ok := ss.s.file(ss.abs(arg)); ok {
+			ss.reply(213, fmt.Sprint(len(content)))
+		} else {
+			ss.reply(550, "no such file")
+		}
+	case "HASH":
+		content, ok := ss.s.file(ss.abs(arg))
+		if ss.s.NoHash || !ok {
+			ss.reply(550, "cannot hash")
+			break
+		}
+		sum := sha256.Sum256(content)
+		ss.reply(213, fmt.Sprintf("SHA-256 0-%d %s %s", len(content), hex.EncodeToString(sum[:]), arg))
+	case "DELE":
+		name := ss.abs(arg)
+		ss.s.mu.Lock()
+		_, ok := ss.s.files[name]
+		delete(ss.s.files, name)
+		ss.s.mu.Unlock()
+		if ok {
+			ss.reply(250, "deleted")
+		} else {
+			ss.reply(550, "no such file")
+		}
+	default:
+		ss.reply(502, "command not implemented")
+	}
+	return true
+}
+
+// transfer accepts the data connection opened after EPSV/PASV and runs fn on it.
+func (ss *session) transfer(fn func(net.Conn) error) {
+	if ss.pasv == nil {
+		ss.reply(425, "use EPSV or PASV first")
+		return
+	}
+	ss.pasv.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	data, err := ss.pasv.Accept()
+	ss.closePasv()
+	if err != nil {
+		ss.reply(425, "no data connection")
+		return
+	}
+	ss.reply(150, "opening data connection")
+	err = fn(data)
+	data.Close()
+	if err != nil {
+		ss.reply(426, err.Error())
+		return
+	}
+	ss.reply(226, "transfer complete")
+}
+
+func (ss *session) openPasv() (int, error) {
+	ss.closePasv()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	ss.pasv = l
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (ss *session) closePasv() {
+	if ss.pasv != nil {
+		ss.pasv.Close()
+		ss.pasv = nil
+	}
+}
+
+func (ss *session) abs(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return path.Clean(name)
+	}
+	return path.Join(ss.cwd, name)
+}
+
+func (s *Server) isDir(dir string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirs[dir]
+}
+
+func (s *Server) file(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.files[name]
+	return content, ok
+}
+
+// list returns the file and directory names directly inside dir.
+func (s *Server) list(dir string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.files {
+		if path.Dir(name) == dir {
+			names = append(names, path.Base(name))
+		}
+	}
+	for name := range s.dirs {
+		if name != "/" && path.Dir(name) == dir {
+			names = append(names, path.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,6 +2,8 @@ package gui
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"ahMakerdir/internal/config"
 	"ahMakerdir/internal/logic"
@@ -11,6 +13,7 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
@@ -35,12 +38,100 @@ func RunApp() {
 	workPathEntry := widget.NewEntry()
 	workPathEntry.SetText(cfg.WorkPath)
 
+	inputFileEntry := widget.NewEntry()
+	inputFileEntry.SetPlaceHolder("Only .xlsx/.xlsm/.csv/.tsv in Work Path")
+	inputFileEntry.SetText(cfg.InputFile)
+	inputFileBtn := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		openDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if reader == nil {
+				return // Cancelled
+			}
+			path := reader.URI().Path()
+			reader.Close()
+			// Keep files inside the work path relative so the config survives a moved work path
+			if rel, err := filepath.Rel(workPathEntry.Text, path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+			inputFileEntry.SetText(path)
+		}, myWindow)
+		openDialog.SetFilter(storage.NewExtensionFileFilter([]string{".xlsx", ".xlsm", ".csv", ".tsv"}))
+		if lister, err := storage.ListerForURI(storage.NewFileURI(workPathEntry.Text)); err == nil {
+			openDialog.SetLocation(lister)
+		}
+		openDialog.Show()
+	})
+
 	picDirEntry := widget.NewEntry()
 	picDirEntry.SetText(cfg.PictureDirName)
 
+	sheetsEntry := widget.NewEntry()
+	sheetsEntry.SetPlaceHolder("Active sheet; or name/index, e.g. 2 or BrandA=orgA, BrandB=orgB")
+	sheetsEntry.SetText(cfg.Sheets)
+
 	sizeTablePathEntry := widget.NewEntry()
 	sizeTablePathEntry.SetText(cfg.SizeTablePath)
 
+	sizeTableRulesEntry := widget.NewEntry()
+	sizeTableRulesEntry.SetPlaceHolder("style, prefix:5, sheet:SizeTables, code")
+	sizeTableRulesEntry.SetText(cfg.SizeTableRules)
+
+	sizeTableGenCheck := widget.NewCheck("Generate missing size tables from data", nil)
+	sizeTableGenCheck.SetChecked(cfg.SizeTableGenerate)
+
+	sizeTableDataEntry := widget.NewEntry()
+	sizeTableDataEntry.SetPlaceHolder("Measurements sheet name or .csv/.tsv file")
+	sizeTableDataEntry.SetText(cfg.SizeTableData)
+
+	sizeTableSizeEntry := widget.NewEntry()
+	sizeTableSizeEntry.SetPlaceHolder("1000x1000")
+	sizeTableSizeEntry.SetText(cfg.SizeTableImageSize)
+
+	sizeTableFontEntry := widget.NewEntry()
+	sizeTableFontEntry.SetPlaceHolder(`e.g. C:\Windows\Fonts\msjh.ttc`)
+	sizeTableFontEntry.SetText(cfg.SizeTableFont)
+
+	swatchModeSelect := widget.NewSelect([]string{"off", logic.SwatchSample, logic.SwatchColumn}, nil)
+	swatchModeSelect.SetSelected("off")
+	if cfg.SwatchGenerate != "" {
+		swatchModeSelect.SetSelected(cfg.SwatchGenerate)
+	}
+
+	swatchRegionEntry := widget.NewEntry()
+	swatchRegionEntry.SetPlaceHolder("x,y,width,height e.g. 0.4,0.4,0.2,0.2")
+	swatchRegionEntry.SetText(cfg.SwatchRegion)
+
+	swatchColumnEntry := widget.NewEntry()
+	swatchColumnEntry.SetPlaceHolder("G")
+	swatchColumnEntry.SetText(cfg.SwatchColorColumn)
+
+	swatchSizeEntry := widget.NewEntry()
+	swatchSizeEntry.SetPlaceHolder("100x100")
+	swatchSizeEntry.SetText(cfg.SwatchSize)
+
+	sourceIndexEntry := widget.NewEntry()
+	sourceIndexEntry.SetPlaceHolder("e.g. source_index.json (empty = list folders every run)")
+	sourceIndexEntry.SetText(cfg.SourceIndexCache)
+
+	sourceMirrorEntry := widget.NewEntry()
+	sourceMirrorEntry.SetPlaceHolder(`Local folder, e.g. D:\ShopeeMirror`)
+	sourceMirrorEntry.SetText(cfg.SourceMirrorPath)
+
+	filenameTemplateEntry := widget.NewEntry()
+	filenameTemplateEntry.SetPlaceHolder("{item}_{seq:2}{role:duplicate=_01}{ext}")
+	filenameTemplateEntry.SetText(cfg.FilenameTemplate)
+
+	defaultImageRulesEntry := widget.NewEntry()
+	defaultImageRulesEntry.SetPlaceHolder("J=1, K=2 (column, image number, first or last = is_def)")
+	defaultImageRulesEntry.SetText(cfg.DefaultImageRules)
+
+	duplicateRulesEntry := widget.NewEntry()
+	duplicateRulesEntry.SetPlaceHolder("copy=1 2, is_def=0, sort=after (or sort=offset:100, copy=none)")
+	duplicateRulesEntry.SetText(cfg.DuplicateRules)
+
 	colorPicPathEntry := widget.NewEntry()
 	colorPicPathEntry.SetText(cfg.ColorPicPath)
 
@@ -62,6 +153,16 @@ func RunApp() {
 	apiKeyEntry.SetText(cfg.ApiKey)
 	apiKeyEntry.SetPlaceHolder("API Auth Key")
 
+	apiSignCheck := widget.NewCheck("Sign requests with HMAC-SHA256 instead of sending the key", nil)
+	apiSignCheck.SetChecked(cfg.ApiSignRequests)
+
+	apiRetriesEntry := widget.NewEntry()
+	apiRetriesEntry.SetText(fmt.Sprintf("%d", cfg.ApiRetries))
+
+	apiChunkEntry := widget.NewEntry()
+	apiChunkEntry.SetText(fmt.Sprintf("%d", cfg.ApiChunkSize))
+	apiChunkEntry.SetPlaceHolder("0 = send all at once")
+
 	ftpHostEntry := widget.NewEntry()
 	ftpHostEntry.SetText(cfg.FtpHost)
 
@@ -74,6 +175,25 @@ func RunApp() {
 	ftpPassEntry := widget.NewPasswordEntry()
 	ftpPassEntry.SetText(cfg.FtpPassword)
 
+	remotePathEntry := widget.NewEntry()
+	remotePathEntry.SetText(cfg.RemotePathTemplate)
+	remotePathEntry.SetPlaceHolder("GoodsColor/{date}/{filename}")
+
+	collisionSelect := widget.NewSelect(logic.CollisionPolicies, nil)
+	collisionSelect.SetSelected(cfg.RemoteCollisionPolicy)
+	if collisionSelect.Selected == "" {
+		collisionSelect.SetSelected(logic.CollisionOverwrite)
+	}
+
+	transactionalCheck := widget.NewCheck("Remove uploaded files if the API call fails", nil)
+	transactionalCheck.SetChecked(cfg.TransactionalUpload)
+
+	verifyCheck := widget.NewCheck("Verify size/checksum after upload", nil)
+	verifyCheck.SetChecked(cfg.VerifyUpload)
+
+	writeBackCheck := widget.NewCheck("Save a copy of the Excel with upload results", nil)
+	writeBackCheck.SetChecked(cfg.ExcelWriteBack)
+
 
 
 	// Log Area - using RichText for better text visibility
@@ -103,23 +223,51 @@ func RunApp() {
 		})
 	}
 
-	// Buttons
-	saveBtn := widget.NewButton("Save Config", func() {
+	// syncConfigFromWidgets copies the values shown in the form into cfg
+	syncConfigFromWidgets := func() {
 		cfg.WorkPath = workPathEntry.Text
 		cfg.PictureDirName = picDirEntry.Text
+		cfg.InputFile = inputFileEntry.Text
+		cfg.Sheets = sheetsEntry.Text
 		cfg.SizeTablePath = sizeTablePathEntry.Text
+		cfg.SizeTableRules = sizeTableRulesEntry.Text
+		cfg.SizeTableGenerate = sizeTableGenCheck.Checked
+		cfg.SizeTableData = sizeTableDataEntry.Text
+		cfg.SizeTableImageSize = sizeTableSizeEntry.Text
+		cfg.SizeTableFont = sizeTableFontEntry.Text
+		cfg.SwatchGenerate = swatchModeSelect.Selected
+		cfg.SwatchRegion = swatchRegionEntry.Text
+		cfg.SwatchColorColumn = swatchColumnEntry.Text
+		cfg.SwatchSize = swatchSizeEntry.Text
+		cfg.SourceIndexCache = sourceIndexEntry.Text
+		cfg.SourceMirrorPath = sourceMirrorEntry.Text
+		cfg.FilenameTemplate = filenameTemplateEntry.Text
+		cfg.DefaultImageRules = defaultImageRulesEntry.Text
+		cfg.DuplicateRules = duplicateRulesEntry.Text
 		cfg.ColorPicPath = colorPicPathEntry.Text
 		cfg.Width = widthEntry.Text
 		cfg.Height = heightEntry.Text
 		fmt.Sscanf(qualityEntry.Text, "%d", &cfg.Quality)
-		
+
 		cfg.ApiUrl = apiUrlEntry.Text
 		cfg.ApiKey = apiKeyEntry.Text
+		fmt.Sscanf(apiChunkEntry.Text, "%d", &cfg.ApiChunkSize)
+		fmt.Sscanf(apiRetriesEntry.Text, "%d", &cfg.ApiRetries)
+		cfg.ApiSignRequests = apiSignCheck.Checked
 		cfg.FtpHost = ftpHostEntry.Text
 		cfg.FtpPort = ftpPortEntry.Text
 		cfg.FtpUser = ftpUserEntry.Text
 		cfg.FtpPassword = ftpPassEntry.Text
+		cfg.RemotePathTemplate = remotePathEntry.Text
+		cfg.RemoteCollisionPolicy = collisionSelect.Selected
+		cfg.VerifyUpload = verifyCheck.Checked
+		cfg.TransactionalUpload = transactionalCheck.Checked
+		cfg.ExcelWriteBack = writeBackCheck.Checked
+	}
 
+	// Buttons
+	saveBtn := widget.NewButton("Save Config", func() {
+		syncConfigFromWidgets()
 
 		if err := config.Save(cfgPath, cfg); err != nil {
 			dialog.ShowError(err, myWindow)
@@ -130,13 +278,35 @@ func RunApp() {
 
 	var smallDirs []string // Store result from split to pass to compress
 
+	validateBtn := widget.NewButton("Validate Excel", func() {
+		logFunc("--- Validating Excel ---")
+		// Update config from UI
+		syncConfigFromWidgets()
+
+		go func() {
+			issues, err := logic.ValidateExcel(cfg, func(msg string) {
+				logFunc(msg)
+			})
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				logFunc(fmt.Sprintf("Error: %v", err))
+				return
+			}
+			errorCount := logic.CountErrors(issues)
+			fyne.Do(func() {
+				if errorCount > 0 {
+					dialog.ShowInformation("Validation", fmt.Sprintf("%d errors, %d warnings. Split will not run until the errors are fixed; see the log for rows and columns.", errorCount, len(issues)-errorCount), myWindow)
+				} else {
+					dialog.ShowInformation("Validation", fmt.Sprintf("No errors, %d warnings.", len(issues)), myWindow)
+				}
+			})
+		}()
+	})
+
 	runSplitBtn := widget.NewButton("1. Run Split", func() {
 		logFunc("--- Starting Split ---")
 		// Update config from UI before running
-		cfg.WorkPath = workPathEntry.Text
-		cfg.PictureDirName = picDirEntry.Text
-		cfg.SizeTablePath = sizeTablePathEntry.Text
-		cfg.ColorPicPath = colorPicPathEntry.Text
+		syncConfigFromWidgets()
 
 		go func() {
 			var err error
@@ -160,9 +330,7 @@ func RunApp() {
 	runCompressBtn := widget.NewButton("2. Run Compress", func() {
 		logFunc("--- Starting Compress ---")
 		// Update config from UI
-		cfg.Width = widthEntry.Text
-		cfg.Height = heightEntry.Text
-		fmt.Sscanf(qualityEntry.Text, "%d", &cfg.Quality)
+		syncConfigFromWidgets()
 
 		go func() {
 			// If smallDirs is empty (user restarted app), logic.RunCompress will scan
@@ -185,13 +353,7 @@ func RunApp() {
 	runUploadBtn := widget.NewButton("3. Run Upload", func() {
 		logFunc("--- Starting Upload ---")
 		// Update config from UI
-		cfg.ApiUrl = apiUrlEntry.Text
-		cfg.ApiKey = apiKeyEntry.Text
-		cfg.FtpHost = ftpHostEntry.Text
-		cfg.FtpPort = ftpPortEntry.Text
-		cfg.FtpUser = ftpUserEntry.Text
-		cfg.FtpPassword = ftpPassEntry.Text
-
+		syncConfigFromWidgets()
 
 		go func() {
 			err := logic.RunUpload(cfg, func(msg string) {
@@ -209,6 +371,45 @@ func RunApp() {
 		}()
 	})
 
+	replayBtn := widget.NewButton("Replay API Call", func() {
+		// Update config from UI
+		syncConfigFromWidgets()
+
+		openDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if reader == nil {
+				return // Cancelled
+			}
+			payloadFile := reader.URI().Path()
+			reader.Close()
+
+			logFunc("--- Starting API Replay ---")
+			go func() {
+				err := logic.RunReplay(cfg, payloadFile, func(msg string) {
+					logFunc(msg)
+				})
+				if err != nil {
+					dialog.ShowError(err, myWindow)
+					logFunc(fmt.Sprintf("Error: %v", err))
+				} else {
+					fyne.Do(func() {
+						dialog.ShowInformation("Done", "API Replay Completed!", myWindow)
+					})
+					logFunc("--- API Replay Completed ---")
+				}
+			}()
+		}, myWindow)
+		openDialog.SetFilter(storage.NewExtensionFileFilter([]string{".json"}))
+		// Start in ApiResults where RunUpload saves the payloads
+		if lister, err := storage.ListerForURI(storage.NewFileURI(filepath.Join(cfg.WorkPath, "ApiResults"))); err == nil {
+			openDialog.SetLocation(lister)
+		}
+		openDialog.Show()
+	})
+
 	runAllBtn := widget.NewButton("Run ALL", func() {
 		logFunc("--- Running ALL ---")
 		runSplitBtn.OnTapped()
@@ -219,17 +420,41 @@ func RunApp() {
 	form := container.New(layout.NewFormLayout(),
 		widget.NewLabel("Work Path:"), workPathEntry,
 		widget.NewLabel("Picture Dir Name:"), picDirEntry,
+		widget.NewLabel("Input File:"), container.NewBorder(nil, nil, nil, inputFileBtn, inputFileEntry),
+		widget.NewLabel("Excel Sheets:"), sheetsEntry,
 		widget.NewLabel("Size Table Path:"), sizeTablePathEntry,
+		widget.NewLabel("Size Table Lookup:"), sizeTableRulesEntry,
+		widget.NewLabel("Size Table Generation:"), sizeTableGenCheck,
+		widget.NewLabel("Size Table Data:"), sizeTableDataEntry,
+		widget.NewLabel("Size Table Image Size:"), sizeTableSizeEntry,
+		widget.NewLabel("Size Table Font:"), sizeTableFontEntry,
+		widget.NewLabel("Color Swatch Generation:"), swatchModeSelect,
+		widget.NewLabel("Swatch Sample Region:"), swatchRegionEntry,
+		widget.NewLabel("Swatch Color Column:"), swatchColumnEntry,
+		widget.NewLabel("Swatch Size:"), swatchSizeEntry,
+		widget.NewLabel("Source Index Cache:"), sourceIndexEntry,
+		widget.NewLabel("Source Mirror Folder:"), sourceMirrorEntry,
+		widget.NewLabel("Filename Template:"), filenameTemplateEntry,
+		widget.NewLabel("Default Image Rules:"), defaultImageRulesEntry,
+		widget.NewLabel("Duplicate Rules:"), duplicateRulesEntry,
 		widget.NewLabel("Color Pic Path:"), colorPicPathEntry,
 		widget.NewLabel("Resize Width:"), widthEntry,
 		widget.NewLabel("Resize Height:"), heightEntry,
 		widget.NewLabel("Quality (0-100):"), qualityEntry,
 		widget.NewLabel("Laravel API URL:"), apiUrlEntry,
 		widget.NewLabel("API Auth Key:"), apiKeyEntry,
+		widget.NewLabel("API Signing:"), apiSignCheck,
+		widget.NewLabel("API Chunk Size (items):"), apiChunkEntry,
+		widget.NewLabel("API Retries:"), apiRetriesEntry,
 		widget.NewLabel("FTP Host:"), ftpHostEntry,
 		widget.NewLabel("FTP Port:"), ftpPortEntry,
 		widget.NewLabel("FTP User:"), ftpUserEntry,
 		widget.NewLabel("FTP Password:"), ftpPassEntry,
+		widget.NewLabel("Remote Path Template:"), remotePathEntry,
+		widget.NewLabel("If Remote File Exists:"), collisionSelect,
+		widget.NewLabel("Upload Check:"), verifyCheck,
+		widget.NewLabel("All or Nothing:"), transactionalCheck,
+		widget.NewLabel("Excel Results:"), writeBackCheck,
 
 	)
 
@@ -237,7 +462,7 @@ func RunApp() {
 	formScroll := container.NewVScroll(form)
 	formScroll.SetMinSize(fyne.NewSize(0, 250)) // Ensure visible height
 
-	actions := container.NewHBox(saveBtn, layout.NewSpacer(), runSplitBtn, runCompressBtn, runUploadBtn, runAllBtn)
+	actions := container.NewHBox(saveBtn, layout.NewSpacer(), validateBtn, runSplitBtn, runCompressBtn, runUploadBtn, replayBtn, runAllBtn)
 
 	topContainer := container.NewVBox(widget.NewLabel("Configuration"), formScroll, actions)
 	bottomContainer := container.NewVBox(widget.NewLabel("Logs"), logScroll)
@@ -0,0 +1,272 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ahMakerdir/internal/api"
+	"ahMakerdir/internal/config"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// RunReplay re-submits a payload file saved by RunUpload, then runs the usual
+// not_found_sns cleanup and ApiResults saving. Use it when the upload went
+// through but the API could not be reached. Images the API rejected are
+// reported as an error.
+func RunReplay(cfg config.Config, payloadFile string, log func(string)) error {
+	data, err := os.ReadFile(payloadFile)
+	if err != nil {
+		return fmt.Errorf("failed to read payload file: %w", err)
+	}
+	apiPayload := make(api.Payload)
+	if err := json.Unmarshal(data, &apiPayload); err != nil {
+		return fmt.Errorf("invalid payload file %s: %w", filepath.Base(payloadFile), err)
+	}
+	if len(apiPayload) == 0 {
+		return fmt.Errorf("payload file %s is empty", filepath.Base(payloadFile))
+	}
+	if cfg.ApiUrl == "" {
+		return fmt.Errorf("API URL not set")
+	}
+	log(fmt.Sprintf("Replaying %d images from %s", len(apiPayload), filepath.Base(payloadFile)))
+
+	// FTP is only needed to clean up not found SNs
+	c, err := connectFtp(cfg, log)
+	if err != nil {
+		return err
+	}
+	defer c.Quit()
+
+	// The files were uploaded by an earlier run, a failed replay must not remove them
+	outcome := submitPayload(c, cfg, apiPayload, nil, log)
+	if len(outcome.Failed) == len(apiPayload) {
+		return fmt.Errorf("all %d images rejected by the API, no IDs were saved", len(apiPayload))
+	}
+	if len(outcome.Failed) > 0 {
+		return fmt.Errorf("%d of %d images rejected by the API, see the log", len(outcome.Failed), len(apiPayload))
+	}
+	return nil
+}
+
+// savePayload writes the API payload to ApiResults/api_payload_<timestamp>.json.
+func savePayload(workPath string, apiPayload api.Payload) (string, error) {
+	resultsDir := filepath.Join(workPath, "ApiResults")
+	if err := os.MkdirAll(resultsDir, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(apiPayload, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(resultsDir, fmt.Sprintf("api_payload_%s.json", time.Now().Format("20060102_150405")))
+	return filePath, os.WriteFile(filePath, data, 0644)
+}
+
+// apiOutcome is what the API made of a payload.
+type apiOutcome struct {
+	Accepted map[string]bool   // Payload keys in accepted chunks
+	Failed   map[string]string // Payload key -> error of its chunk
+	NotFound map[string]bool   // SNs reported in not_found_sns
+	Response *api.Response     // Merged results of the accepted chunks
+}
+
+// submitPayload sends the payload to the Laravel API in chunks, removes the
+// FTP files of not found SNs and saves the returned IDs to ApiResults.
+// onReject, when not nil, is called with every chunk the API failed, refused
+// or answered in an unknown format.
+func submitPayload(c *ftp.ServerConn, cfg config.Config, apiPayload api.Payload, onReject func(api.Payload), log func(string)) *apiOutcome {
+	client := api.NewClient(cfg, log)
+	outcome := &apiOutcome{
+		Accepted: make(map[string]bool),
+		Failed:   make(map[string]string),
+		NotFound: make(map[string]bool),
+	}
+
+	// Send in chunks so one slow or bad batch does not fail everything.
+	// Results of the accepted chunks are merged below. All-or-nothing mode
+	// sends one chunk, a rollback must not leave accepted rows without files.
+	chunkSize := cfg.ApiChunkSize
+	if cfg.TransactionalUpload && chunkSize > 0 {
+		log("TransactionalUpload is on, the payload is sent in one request.")
+		chunkSize = 0
+	}
+	chunks := chunkPayload(apiPayload, chunkSize)
+	if len(chunks) == 0 {
+		log("No manifest images uploaded, nothing to send to the API.")
+	}
+	apiResp := &api.Response{}
+	outcome.Response = apiResp
+	accepted := 0
+	for i, chunk := range chunks {
+		log(fmt.Sprintf("Calling Laravel API (chunk %d/%d, %d images)...", i+1, len(chunks), len(chunk)))
+
+		chunkResp, err := client.Submit(chunk)
+		if errors.Is(err, api.ErrUnsupportedVersion) {
+			// The backend may well have stored the chunk, it cannot be told apart from a refusal
+			log("---------------------------------------------------")
+			log(fmt.Sprintf("API RESPONSE NOT UNDERSTOOD (chunk %d): %v", i+1, err))
+			log("Check the database before re-sending this chunk.")
+			log("---------------------------------------------------")
+			for key := range chunk {
+				outcome.Failed[key] = err.Error()
+			}
+			if onReject != nil {
+				onReject(chunk)
+			}
+			continue
+		}
+		if err != nil {
+			var apiErr *api.Error
+			if errors.As(err, &apiErr) && apiErr.Message != "" {
+				log("---------------------------------------------------")
+				log(fmt.Sprintf("API SERVER ERROR: %s", apiErr.Message))
+				if apiErr.Response != nil {
+					logItemErrors(apiErr.Response.Errors, log)
+				}
+				log("---------------------------------------------------")
+			} else {
+				log(fmt.Sprintf("API Error: %v", err))
+			}
+			for key := range chunk {
+				outcome.Failed[key] = err.Error()
+			}
+			if onReject != nil {
+				onReject(chunk)
+			}
+			continue
+		}
+
+		for key := range chunk {
+			outcome.Accepted[key] = true
+		}
+		accepted++
+		logItemErrors(chunkResp.Errors, log)
+		apiResp.Merge(chunkResp)
+	}
+
+	if accepted > 0 {
+		log(fmt.Sprintf("API notification sent successfully (%d/%d chunks accepted).", accepted, len(chunks)))
+
+		// Handle Not Found Warnings & Cleanup FTP
+		if len(apiResp.NotFoundSNs) > 0 {
+			log("---------------------------------------------------")
+			log(fmt.Sprintf("WARNING: %d Items Not Found in Database. Cleaning up FTP...", len(apiResp.NotFoundSNs)))
+			log("-------------以下料號上傳失敗-----------------")
+
+			// Create map for SN lookup
+			missingSNs := make(map[string]bool)
+			for _, sn := range apiResp.NotFoundSNs {
+				missingSNs[sn] = true
+				outcome.NotFound[sn] = true
+				log(fmt.Sprintf(" - %s (Not found, deleting from FTP)", sn))
+			}
+
+			deletedCount := 0
+			deletedPaths := make(map[string]bool)
+
+			for _, item := range apiPayload {
+				if missingSNs[item.ExcelColD] {
+					// 1. Delete main image
+					if item.FtpPath != "" && !deletedPaths[item.FtpPath] {
+						ftpPath := strings.TrimPrefix(item.FtpPath, "/image/")
+						if err := c.Delete(ftpPath); err == nil {
+							deletedCount++
+						}
+						deletedPaths[item.FtpPath] = true
+					}
+
+					// 2. Delete color pic
+					if item.ColorPic != "" && !deletedPaths[item.ColorPic] {
+						ftpColorPath := strings.TrimPrefix(item.ColorPic, "/image/")
+						c.Delete(ftpColorPath) // Delete silently
+						deletedPaths[item.ColorPic] = true
+					}
+				}
+			}
+			if deletedCount > 0 {
+				log(fmt.Sprintf("Successfully removed %d invalid images from FTP.", deletedCount))
+			}
+			log("---------------------------------------------------")
+		}
+
+		// Prepare Results Directory
+		resultsDir := filepath.Join(cfg.WorkPath, "ApiResults")
+		if _, err := os.Stat(resultsDir); os.IsNotExist(err) {
+			os.MkdirAll(resultsDir, 0755)
+		}
+		timestamp := time.Now().Format("20060102_150405")
+
+		// Helper to save ID list
+		saveIDs := func(name string, ids []int) {
+			if len(ids) > 0 {
+				fileName := fmt.Sprintf("%s_%s.json", name, timestamp)
+				filePath := filepath.Join(resultsDir, fileName)
+				if data, err := json.MarshalIndent(ids, "", "  "); err == nil {
+					if err := os.WriteFile(filePath, data, 0644); err == nil {
+						log(fmt.Sprintf("Saved %d %s to: %s", len(ids), name, fileName))
+					} else {
+						log(fmt.Sprintf("Error saving %s: %v", name, err))
+					}
+				}
+			}
+		}
+
+		// Save GoodsColorPic IDs
+		saveIDs("success_goods_color_pic_ids", apiResp.SuccessGoodsColorPicIDs)
+
+		// Save GoodsColor IDs
+		saveIDs("success_goods_color_ids", apiResp.SuccessGoodsColorIDs)
+	}
+	if accepted < len(chunks) {
+		log(fmt.Sprintf("WARNING: %d of %d API chunks failed.", len(chunks)-accepted, len(chunks)))
+	}
+	return outcome
+}
+
+// chunkPayload splits the payload into chunks of at most size item codes.
+// All images of one item code stay in the same chunk. A size of 0 or less
+// sends everything in one chunk.
+func chunkPayload(payload api.Payload, size int) []api.Payload {
+	groups := make(map[string][]string)
+	var codes []string
+	for filename, item := range payload {
+		if _, ok := groups[item.ExcelColD]; !ok {
+			codes = append(codes, item.ExcelColD)
+		}
+		groups[item.ExcelColD] = append(groups[item.ExcelColD], filename)
+	}
+	sort.Strings(codes)
+
+	if size <= 0 {
+		size = len(codes)
+	}
+	var chunks []api.Payload
+	for start := 0; start < len(codes); start += size {
+		end := start + size
+		if end > len(codes) {
+			end = len(codes)
+		}
+		chunk := make(api.Payload)
+		for _, code := range codes[start:end] {
+			for _, filename := range groups[code] {
+				chunk[filename] = payload[filename]
+			}
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// logItemErrors lists the per-item problems reported by the API.
+func logItemErrors(itemErrors []api.ItemError, log func(string)) {
+	for _, e := range itemErrors {
+		log(fmt.Sprintf(" ! %s", e))
+	}
+}
@@ -0,0 +1,120 @@
+package logic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// Remote collision policies for RemoteCollisionPolicy
+const (
+	CollisionOverwrite = "overwrite" // Replace the remote file (original behavior)
+	CollisionSkip      = "skip"      // Keep the remote file, do not upload or notify the API
+	CollisionRename    = "rename"    // Upload as <name>_<hash>.<ext>
+)
+
+// CollisionPolicies lists the accepted values, default first.
+var CollisionPolicies = []string{CollisionOverwrite, CollisionSkip, CollisionRename}
+
+// validateCollisionPolicy rejects unknown RemoteCollisionPolicy values, an
+// empty one is CollisionOverwrite.
+func validateCollisionPolicy(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	for _, p := range CollisionPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown remote collision policy %q (allowed: %s)", policy, strings.Join(CollisionPolicies, ", "))
+}
+
+// remoteIndex caches the file names of remote directories, listing each one once.
+// Files uploaded during the run are added so collisions inside a batch are caught too.
+type remoteIndex struct {
+	c        *ftp.ServerConn
+	dirs     map[string]map[string]bool
+	uploaded map[string]bool // Remote paths written by this run
+}
+
+func newRemoteIndex(c *ftp.ServerConn) *remoteIndex {
+	return &remoteIndex{c: c, dirs: make(map[string]map[string]bool), uploaded: make(map[string]bool)}
+}
+
+func (r *remoteIndex) dir(dir string) map[string]bool {
+	names, ok := r.dirs[dir]
+	if ok {
+		return names
+	}
+	names = make(map[string]bool)
+	// A missing directory simply has no collisions
+	if list, err := r.c.NameList(dir); err == nil {
+		for _, name := range list {
+			names[path.Base(name)] = true
+		}
+	}
+	r.dirs[dir] = names
+	return names
+}
+
+func (r *remoteIndex) exists(remotePath string) bool {
+	return r.dir(path.Dir(remotePath))[path.Base(remotePath)]
+}
+
+// existedBefore reports whether the file was on the server before this run.
+func (r *remoteIndex) existedBefore(remotePath string) bool {
+	return r.exists(remotePath) && !r.uploaded[remotePath]
+}
+
+func (r *remoteIndex) add(remotePath string) {
+	r.dir(path.Dir(remotePath))[path.Base(remotePath)] = true
+	r.uploaded[remotePath] = true
+}
+
+// resolveCollision applies the policy to a planned upload. It returns the remote
+// path the file should end up at (empty when skipped) and whether it still has
+// to be uploaded.
+func resolveCollision(idx *remoteIndex, policy, localPath, remotePath string) (string, bool, error) {
+	if !idx.exists(remotePath) {
+		return remotePath, true, nil
+	}
+
+	switch policy {
+	case "", CollisionOverwrite:
+		return remotePath, true, nil
+	case CollisionSkip:
+		return "", false, nil
+	case CollisionRename:
+		sum, err := fileHash(localPath)
+		if err != nil {
+			return "", false, err
+		}
+		ext := path.Ext(remotePath)
+		renamed := fmt.Sprintf("%s_%s%s", strings.TrimSuffix(remotePath, ext), sum[:8], ext)
+		// Same name and same hash means the same content is already there
+		return renamed, !idx.exists(renamed), nil
+	}
+	return "", false, fmt.Errorf("unknown collision policy %q", policy)
+}
+
+// fileHash returns the hex SHA-256 of a local file.
+func fileHash(localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
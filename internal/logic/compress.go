@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"ahMakerdir/internal/config"
+	"ahMakerdir/internal/manifest"
 
 	"github.com/disintegration/imaging"
 )
@@ -33,6 +34,20 @@ func RunCompress(targetDirs []string, cfg config.Config, progress func(string))
 
 	progress(fmt.Sprintf("Found %d directories to process", len(targetDirs)))
 
+	// The manifest gets the new size and hash of every SMALL file
+	runManifest, err := loadManifest(cfg.WorkPath)
+	if err != nil {
+		return err
+	}
+
+	// Generated swatches are already at SwatchSize
+	swatches := make(map[string]bool)
+	for key, meta := range manifestEntries(runManifest) {
+		if meta.Type == manifest.TypeColor && meta.ColorPicGenerated {
+			swatches[key] = true
+		}
+	}
+
 	for _, dir := range targetDirs {
 		entries, err := os.ReadDir(dir)
 		if err != nil {
@@ -49,8 +64,10 @@ func RunCompress(targetDirs []string, cfg config.Config, progress func(string))
 			if !strings.HasSuffix(lowerName, ".jpg") && !strings.HasSuffix(lowerName, ".png") {
 				continue
 			}
-
 			filePath := filepath.Join(dir, entry.Name())
+			if swatches[entryKey(cfg.WorkPath, filePath)] {
+				continue
+			}
 
 			// Resize
 			err := resizeImage(filePath, width, height, quality)
@@ -58,11 +75,26 @@ func RunCompress(targetDirs []string, cfg config.Config, progress func(string))
 				progress(fmt.Sprintf("Failed to resize %s: %v", entry.Name(), err))
 			} else {
 				// progress(fmt.Sprintf("Resized %s", entry.Name())) // Too verbose?
+				if runManifest != nil {
+					if _, f, ok := runManifest.Lookup(entryKey(cfg.WorkPath, filePath)); ok {
+						if r := f.Rendition(manifest.RenditionSmall); r != nil {
+							if err := r.Update(cfg.WorkPath); err != nil {
+								progress(fmt.Sprintf("Warning: Could not update %s in the manifest: %v", entry.Name(), err))
+							}
+						}
+					}
+				}
 			}
 		}
 		progress(fmt.Sprintf("Completed directory: %s", filepath.Base(dir)))
 	}
 
+	if runManifest != nil {
+		if err := runManifest.Save(manifest.Path(cfg.WorkPath)); err != nil {
+			progress(fmt.Sprintf("Warning: Failed to save manifest.json: %v", err))
+		}
+	}
+
 	progress("Compression Process Complete.")
 	return nil
 }
@@ -96,16 +128,22 @@ func resizeImage(path string, width, height, quality int) error {
 	// Resize
 	dst := imaging.Resize(src, width, height, imaging.Lanczos)
 
+	// Keep the format of the extension, FilenameTemplate can give PNG sources .png names
+	format, err := imaging.FormatFromFilename(path)
+	if err != nil || format != imaging.PNG {
+		format = imaging.JPEG
+	}
+
 	// Save to temp buffer first to embed ICC
 	buf := new(bytes.Buffer)
-	err = imaging.Encode(buf, dst, imaging.JPEG, imaging.JPEGQuality(quality))
+	err = imaging.Encode(buf, dst, format, imaging.JPEGQuality(quality))
 	if err != nil {
 		return err
 	}
 
-	// If we have a profile, embed it
+	// If we have a profile, embed it (JPEG only)
 	var finalOutput io.Reader = buf
-	if len(profile) > 0 {
+	if len(profile) > 0 && format == imaging.JPEG {
 		outBuf := new(bytes.Buffer)
 		if err := embedICCProfile(outBuf, buf, profile); err == nil {
 			finalOutput = outBuf
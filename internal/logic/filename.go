@@ -0,0 +1,178 @@
+package logic
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultFilenameTemplate names split images like earlier versions did:
+// ITEM1_01.jpg, and ITEM1_01_01.jpg for the duplicate of a default image.
+const DefaultFilenameTemplate = "{item}_{seq:2}{role:duplicate=_01}{ext}"
+
+// Image roles for the {role} placeholder
+const (
+	roleImage     = "image"
+	roleDefault   = "default"   // is_def 1 or 2
+	roleDuplicate = "duplicate" // Copy of a default image, sorted after the row's images
+)
+
+// filenameVars are the values available to the filename template.
+type filenameVars struct {
+	Item  string // Base name of the row, the item code unless another row used it first
+	Code  string // Excel column D
+	Color string // Excel column G
+	Seq   int    // Position of the image in the row, from 1
+	Ext   string // Extension of the source picture, lower case with the dot
+	Role  string // roleImage, roleDefault or roleDuplicate
+}
+
+var filenamePlaceholderPattern = regexp.MustCompile(`\{([a-z]+)(?::([^{}]*))?\}`)
+
+// filenameTemplateVars lists the placeholders understood by filenameTemplate.
+var filenameTemplateVars = []string{"item", "code", "color", "seq", "ext", "role"}
+
+// filenameTemplate names the images Split copies. {seq:N} pads the sequence
+// to N digits, {role} gives the role itself and {role:duplicate=_dup,...}
+// the text listed for the role, nothing for roles that are not listed.
+type filenameTemplate struct {
+	tmpl string
+}
+
+// parseFilenameTemplate checks tmpl, an empty one is DefaultFilenameTemplate.
+// Names must stay unique: the template needs {item}, {seq} and {role}, with
+// a separator between {item} and {seq} since images of two items share the
+// OUT folder of their A_B folder (ITEM1 image 12 and ITEM11 image 2 would
+// both be ITEM112 without one), and the duplicate of a default image needs
+// a role text of its own.
+func parseFilenameTemplate(tmpl string) (*filenameTemplate, error) {
+	tmpl = strings.TrimSpace(tmpl)
+	if tmpl == "" {
+		tmpl = DefaultFilenameTemplate
+	}
+	found := make(map[string][]int) // Placeholder -> position of its first use
+	var roleTexts map[string]string
+	afterSeq := "" // Placeholder following {seq} directly
+	for _, m := range filenamePlaceholderPattern.FindAllStringSubmatchIndex(tmpl, -1) {
+		whole, name, arg := tmpl[m[0]:m[1]], tmpl[m[2]:m[3]], ""
+		if m[4] >= 0 {
+			arg = tmpl[m[4]:m[5]]
+		}
+		known := false
+		for _, v := range filenameTemplateVars {
+			if name == v {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown placeholder %s in filename template (allowed: %s)", whole, strings.Join(filenameTemplateVars, ", "))
+		}
+		switch {
+		case name == "seq" && arg != "":
+			if n, err := strconv.Atoi(arg); err != nil || n < 1 || n > 9 {
+				return nil, fmt.Errorf("%s in filename template needs a width from 1 to 9, e.g. {seq:2}", whole)
+			}
+		case name == "role" && arg != "":
+			texts, err := parseRoleText(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s in filename template: %v", whole, err)
+			}
+			roleTexts = texts
+		case arg != "":
+			return nil, fmt.Errorf("%s in filename template takes no argument", whole)
+		}
+		if seq, ok := found["seq"]; ok && seq[1] == m[0] {
+			afterSeq = name
+		}
+		if _, ok := found[name]; !ok {
+			found[name] = m[:2]
+		}
+	}
+	if bad := illegalChars(filenamePlaceholderPattern.ReplaceAllString(tmpl, "")); bad != "" {
+		return nil, fmt.Errorf("filename template %q contains characters not allowed in file names: %s", tmpl, bad)
+	}
+	if found["item"] == nil || found["seq"] == nil || found["role"] == nil {
+		return nil, fmt.Errorf("filename template %q must contain {item}, {seq} and {role}", tmpl)
+	}
+
+	// Something other than digits between {item} and {seq}
+	item, seq := found["item"], found["seq"]
+	between := ""
+	if item[0] < seq[0] {
+		between = tmpl[item[1]:seq[0]]
+	} else {
+		between = tmpl[seq[1]:item[0]]
+	}
+	if strings.Trim(filenamePlaceholderPattern.ReplaceAllString(between, ""), "0123456789") == "" {
+		return nil, fmt.Errorf("filename template %q needs a separator between {item} and {seq}, e.g. {item}_{seq}", tmpl)
+	}
+	// A duplicate must not get the name of its default image, nor run into
+	// the sequence: ITEM_1 and 1 is image 11
+	if roleTexts != nil {
+		dup := roleTexts[roleDuplicate]
+		if dup == roleTexts[roleDefault] || dup == roleTexts[roleImage] {
+			return nil, fmt.Errorf("filename template %q gives a duplicate the name of its default image, add e.g. {role:duplicate=_01}", tmpl)
+		}
+		if afterSeq == "role" {
+			for _, text := range roleTexts {
+				if text != "" && text[0] >= '0' && text[0] <= '9' {
+					return nil, fmt.Errorf("filename template %q continues {seq} with the digits of %q, start role texts with a separator", tmpl, text)
+				}
+			}
+		}
+	}
+
+	t := &filenameTemplate{tmpl: tmpl}
+	name := t.render(filenameVars{Item: "ITEM", Seq: 1, Ext: ".png", Role: roleImage})
+	if ext := strings.ToLower(filepath.Ext(name)); ext != ".jpg" && ext != ".png" {
+		return nil, fmt.Errorf("filename template %q must end in {ext}, .jpg or .png", tmpl)
+	}
+	return t, nil
+}
+
+// parseRoleText reads role=text pairs, e.g. duplicate=_01,default=_main.
+func parseRoleText(arg string) (map[string]string, error) {
+	texts := make(map[string]string)
+	for _, pair := range strings.Split(arg, ",") {
+		role, text, ok := strings.Cut(pair, "=")
+		role = strings.TrimSpace(role)
+		if !ok || (role != roleImage && role != roleDefault && role != roleDuplicate) {
+			return nil, fmt.Errorf("%q is not role=text with role %s, %s or %s", pair, roleImage, roleDefault, roleDuplicate)
+		}
+		if bad := illegalChars(text); bad != "" {
+			return nil, fmt.Errorf("%q contains characters not allowed in file names: %s", pair, bad)
+		}
+		texts[role] = text
+	}
+	return texts, nil
+}
+
+// render returns the file name for one image.
+func (t *filenameTemplate) render(v filenameVars) string {
+	return filenamePlaceholderPattern.ReplaceAllStringFunc(t.tmpl, func(m string) string {
+		parts := filenamePlaceholderPattern.FindStringSubmatch(m)
+		switch name, arg := parts[1], parts[2]; name {
+		case "item":
+			return v.Item
+		case "code":
+			return v.Code
+		case "color":
+			return v.Color
+		case "seq":
+			width, _ := strconv.Atoi(arg)
+			return fmt.Sprintf("%0*d", width, v.Seq)
+		case "ext":
+			return strings.ToLower(v.Ext)
+		case "role":
+			if arg == "" {
+				return v.Role
+			}
+			texts, _ := parseRoleText(arg)
+			return texts[v.Role]
+		}
+		return ""
+	})
+}
@@ -0,0 +1,98 @@
+package logic
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestFilenameTemplate(t *testing.T) {
+	vars := filenameVars{Item: "ITEM1", Code: "ITEM1", Color: "Red", Seq: 10, Ext: ".PNG", Role: roleDefault}
+	for _, tc := range []struct{ tmpl, role, want string }{
+		{"", roleImage, "ITEM1_10.png"},
+		{"", roleDuplicate, "ITEM1_10_01.png"},
+		{"{item}_{color}_{seq:3}{role:default=_main,duplicate=_dup}.jpg", roleDefault, "ITEM1_Red_010_main.jpg"},
+		{"{item}_{color}_{seq:3}{role:default=_main,duplicate=_dup}.jpg", roleImage, "ITEM1_Red_010.jpg"},
+		{"{code}-{item}-{seq}-{role}{ext}", roleDuplicate, "ITEM1-ITEM1-10-duplicate.png"},
+		{"{item}-{seq}{role:duplicate=_01}{ext}", roleImage, "ITEM1-10.png"},
+		{"{seq:2}-{item}{role:duplicate=_dup}{ext}", roleDuplicate, "10-ITEM1_dup.png"},
+	} {
+		tmpl, err := parseFilenameTemplate(tc.tmpl)
+		if err != nil {
+			t.Errorf("parseFilenameTemplate(%q): %v", tc.tmpl, err)
+			continue
+		}
+		vars.Role = tc.role
+		if got := tmpl.render(vars); got != tc.want {
+			t.Errorf("%q as %s = %q, want %q", tc.tmpl, tc.role, got, tc.want)
+		}
+	}
+
+	for tmpl, want := range map[string]string{
+		"{item}_{seq}{size}{ext}":               "unknown placeholder {size}",
+		"{item}_{seq:0}{role}{ext}":             "width from 1 to 9",
+		"{item}_{seq}{role:main=_m}{ext}":       "is not role=text",
+		"{item}_{seq:2}{ext}":                   "must contain {item}, {seq} and {role}",
+		"{item}_{seq}{role:default=_m}{ext}":    "gives a duplicate the name of its default image",
+		"{item}_{seq}{role:duplicate=1}.jpg":    "continues {seq} with the digits of", // ITEM_1 + 1 = ITEM_11
+		"{item}{seq:1}{role:duplicate=_d}{ext}": "needs a separator between {item} and {seq}",
+		"{seq}{item}{role:duplicate=_d}{ext}":   "needs a separator between {item} and {seq}",
+		"{item}{color}{seq}{role}{ext}":         "needs a separator between {item} and {seq}",
+		"{item}0{seq}{role}{ext}":               "needs a separator between {item} and {seq}",
+		"{item}/{seq}{role}{ext}":               "not allowed in file names",
+		"{color}_{seq}{role}{ext}":              "must contain {item}, {seq} and {role}",
+		"{item}_{seq}{role}.gif":                "must end in {ext}",
+	} {
+		if _, err := parseFilenameTemplate(tmpl); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseFilenameTemplate(%q) = %v, want %q", tmpl, err, want)
+		}
+	}
+}
+
+func TestSplitFilenameTemplate(t *testing.T) {
+	cfg := newWorkPath(t)
+	// ITEM2's picture is a PNG
+	os.Remove(filepath.Join(cfg.WorkPath, "org", "a (3).jpg"))
+	if err := imaging.Save(imaging.New(100, 140, image.White.C), filepath.Join(cfg.WorkPath, "org", "a (3).png")); err != nil {
+		t.Fatal(err)
+	}
+	cfg.FilenameTemplate = "{item}_{color}_{seq:3}{role:duplicate=_dup}{ext}"
+
+	smallDirs, err := RunSplit(cfg, testLog(t))
+	if err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	entries := readManifest(t, cfg.WorkPath)
+	for key, isDef := range map[string]int{
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_Red_001.jpg"):       1,
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_Red_001_dup.jpg"):   0,
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_Red_002.jpg"):       2,
+		smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_Blue_001.png"):     1,
+		smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_Blue_001_dup.png"): 0,
+	} {
+		if m, ok := entries[key]; !ok || m.IsDef != isDef {
+			t.Errorf("manifest[%s] = %+v, want is_def %d", key, m, isDef)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cfg.WorkPath, "Brand_Season", "OUT", "ITEM2_Blue_001.png")); err != nil {
+		t.Error(err)
+	}
+
+	// Compression keeps PNG files PNG
+	if err := RunCompress(smallDirs, cfg, testLog(t)); err != nil {
+		t.Fatalf("RunCompress: %v", err)
+	}
+	f, err := os.Open(filepath.Join(cfg.WorkPath, "Brand_Season", "ITEM2_Blue", "SMALL", "ITEM2_Blue_001.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	imgCfg, format, err := image.DecodeConfig(f)
+	if err != nil || format != "png" || imgCfg.Width != 50 {
+		t.Errorf("compressed PNG: format %q, width %d, err %v", format, imgCfg.Width, err)
+	}
+}
@@ -0,0 +1,241 @@
+package logic
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ahMakerdir/internal/config"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Rules of earlier versions: the image numbered in column J is is_def 1, the
+// one in column K is_def 2, and both are copied once more as is_def 0 sorted
+// after the row's images.
+const (
+	DefaultDefaultImageRules = "J=1, K=2"
+	DefaultDuplicateRules    = "copy=1 2, is_def=0, sort=after"
+)
+
+// Sort numbers of the copies for the sort= setting of DuplicateRules
+const (
+	DuplicateSortAfter  = "after"  // Column I + 1, + 2, ... in image order (original behavior)
+	DuplicateSortOffset = "offset" // offset:N, the sort of the copied image + N
+)
+
+// defaultImageRule gives is_def to one image of a row: the one numbered in a
+// column, or a fixed position.
+type defaultImageRule struct {
+	column   int // 0-based column holding an image number, -1 for position rules
+	letter   string
+	position int // Image number, 0 for the last image
+	isDef    int
+}
+
+func (r defaultImageRule) String() string {
+	switch {
+	case r.column >= 0:
+		return fmt.Sprintf("%s=%d", r.letter, r.isDef)
+	case r.position == 0:
+		return fmt.Sprintf("last=%d", r.isDef)
+	case r.position == 1:
+		return fmt.Sprintf("first=%d", r.isDef)
+	}
+	return fmt.Sprintf("%d=%d", r.position, r.isDef)
+}
+
+// imageRules decides the is_def of the images Split copies and which of them
+// are copied a second time, from DefaultImageRules and DuplicateRules.
+type imageRules struct {
+	defaults   []defaultImageRule // The first rule matching an image wins
+	copy       map[int]bool       // is_def values whose images get a copy
+	copyIsDef  int                // is_def of the copies
+	sortMode   string
+	sortOffset int
+}
+
+// newImageRules parses DefaultImageRules and DuplicateRules, empty settings
+// keep the original behavior. DefaultImageRules lists source=is_def pairs,
+// where the source is a column holding an image number (J), an image number
+// (3), first or last; "none" gives no image is_def. DuplicateRules sets
+// copy=<is_def values> (or copy=none), is_def=<value of the copies> and
+// sort=after or sort=offset:N.
+func newImageRules(cfg config.Config) (*imageRules, error) {
+	r := &imageRules{copy: make(map[int]bool), sortMode: DuplicateSortAfter}
+
+	defaults := strings.TrimSpace(cfg.DefaultImageRules)
+	if defaults == "" {
+		defaults = DefaultDefaultImageRules
+	}
+	if !strings.EqualFold(defaults, "none") {
+		for _, part := range strings.Split(defaults, ",") {
+			source, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+			isDef, err := strconv.Atoi(strings.TrimSpace(value))
+			if !ok || err != nil || isDef < 0 {
+				return nil, fmt.Errorf("DefaultImageRules: %q is not source=is_def, e.g. J=1", strings.TrimSpace(part))
+			}
+			rule := defaultImageRule{column: -1, isDef: isDef}
+			source = strings.TrimSpace(source)
+			switch n, err := strconv.Atoi(source); {
+			case strings.EqualFold(source, "first"):
+				rule.position = 1
+			case strings.EqualFold(source, "last"):
+				rule.position = 0
+			case err == nil && n >= 1:
+				rule.position = n
+			default:
+				col, err := excelize.ColumnNameToNumber(source)
+				if err != nil {
+					return nil, fmt.Errorf("DefaultImageRules: %q needs a column, an image number, first or last", source)
+				}
+				rule.column, rule.letter = col-1, strings.ToUpper(source)
+			}
+			r.defaults = append(r.defaults, rule)
+		}
+	}
+
+	duplicates := strings.TrimSpace(cfg.DuplicateRules)
+	if duplicates == "" {
+		duplicates = DefaultDuplicateRules
+	}
+	for _, part := range strings.Split(duplicates, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("DuplicateRules: %q is not key=value", strings.TrimSpace(part))
+		case key == "copy":
+			if strings.EqualFold(value, "none") {
+				continue
+			}
+			for _, field := range strings.Fields(value) {
+				n, err := strconv.Atoi(field)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("DuplicateRules: copy=%s needs is_def values separated by spaces, e.g. copy=1 2", value)
+				}
+				r.copy[n] = true
+			}
+		case key == "is_def":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("DuplicateRules: is_def=%s is not a number", value)
+			}
+			r.copyIsDef = n
+		case key == "sort" && strings.EqualFold(value, DuplicateSortAfter):
+			r.sortMode = DuplicateSortAfter
+		case key == "sort" && strings.HasPrefix(strings.ToLower(value), DuplicateSortOffset+":"):
+			n, err := strconv.Atoi(value[len(DuplicateSortOffset)+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("DuplicateRules: sort=%s needs a positive offset, e.g. offset:100", value)
+			}
+			r.sortMode, r.sortOffset = DuplicateSortOffset, n
+		case key == "sort":
+			return nil, fmt.Errorf("DuplicateRules: sort=%s, use %s or %s:N", value, DuplicateSortAfter, DuplicateSortOffset)
+		default:
+			return nil, fmt.Errorf("DuplicateRules: unknown setting %q (allowed: copy, is_def, sort)", key)
+		}
+	}
+	return r, nil
+}
+
+// isDef returns the is_def of image seq (from 1) of a row with step images.
+func (r *imageRules) isDef(row []string, seq, step int) int {
+	for _, rule := range r.defaults {
+		switch {
+		case rule.column >= 0:
+			if n, err := strconv.Atoi(strings.TrimSpace(cellValue(row, rule.column))); err == nil && n == seq {
+				return rule.isDef
+			}
+		case rule.position == 0 && seq == step, rule.position > 0 && seq == rule.position:
+			return rule.isDef
+		}
+	}
+	return 0
+}
+
+// duplicates reports whether an image with the is_def value gets a copy.
+func (r *imageRules) duplicates(isDef int) bool {
+	return r.copy[isDef]
+}
+
+// duplicateSort returns the sort of the n-th copy (from 1) of the row, made of
+// image seq, in a row of step images.
+func (r *imageRules) duplicateSort(seq, step, n int) int {
+	if r.sortMode == DuplicateSortOffset {
+		return seq + r.sortOffset
+	}
+	return step + n
+}
+
+// columns returns the columns the rules read image numbers from.
+func (r *imageRules) columns() []defaultImageRule {
+	var cols []defaultImageRule
+	for _, rule := range r.defaults {
+		if rule.column >= 0 {
+			cols = append(cols, rule)
+		}
+	}
+	return cols
+}
+
+func (r *imageRules) String() string {
+	var defaults []string
+	for _, rule := range r.defaults {
+		defaults = append(defaults, rule.String())
+	}
+	if len(defaults) == 0 {
+		defaults = []string{"none"}
+	}
+	var copied []int
+	for isDef := range r.copy {
+		copied = append(copied, isDef)
+	}
+	sort.Ints(copied)
+	copies := "none"
+	if len(copied) > 0 {
+		copies = strings.Trim(fmt.Sprint(copied), "[]")
+	}
+	sortText := r.sortMode
+	if r.sortMode == DuplicateSortOffset {
+		sortText = fmt.Sprintf("%s:%d", DuplicateSortOffset, r.sortOffset)
+	}
+	return fmt.Sprintf("is_def %s; copies of is_def %s as is_def %d, sort %s", strings.Join(defaults, ", "), copies, r.copyIsDef, sortText)
+}
+
+// logImagePlan lists the is_def images and copies of every row before
+// anything is copied.
+func logImagePlan(rules *imageRules, sheets []sheetInput, log func(string)) {
+	log(fmt.Sprintf("Image rules: %s", rules))
+	for _, sheet := range sheets {
+		for index, row := range sheet.Rows {
+			if !isDataRow(row) {
+				continue
+			}
+			step, _ := strconv.Atoi(row[8])
+			var defaults, copies []string
+			n := 0
+			for seq := 1; seq <= step; seq++ {
+				isDef := rules.isDef(row, seq, step)
+				if isDef == 0 {
+					continue
+				}
+				defaults = append(defaults, fmt.Sprintf("image %d is_def %d", seq, isDef))
+				if rules.duplicates(isDef) {
+					n++
+					copies = append(copies, fmt.Sprintf("image %d as sort %d", seq, rules.duplicateSort(seq, step, n)))
+				}
+			}
+			line := "no default image"
+			if len(defaults) > 0 {
+				line = strings.Join(defaults, ", ")
+			}
+			if len(copies) > 0 {
+				line += "; copies: " + strings.Join(copies, ", ")
+			}
+			cell := ValidationIssue{Sheet: sheet.Label, Row: index + 1, Column: "D"}.cell()
+			log(fmt.Sprintf("  %s %s: %d images, %s", cell, row[3], step, line))
+		}
+	}
+}
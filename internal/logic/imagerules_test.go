@@ -0,0 +1,101 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"ahMakerdir/internal/config"
+)
+
+func testImageRules(t *testing.T, cfg config.Config) *imageRules {
+	t.Helper()
+	rules, err := newImageRules(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestImageRules(t *testing.T) {
+	row := []string{"Brand", "Season", "S100-1", "ITEM1", "", "", "Red", "", "4", "2", "x"}
+	for _, tc := range []struct {
+		defaults, duplicates string
+		isDef                []int // Images 1 to 4
+		sorts                []int // Sorts of the copies
+	}{
+		{"", "", []int{0, 1, 0, 0}, []int{5}},
+		{"K=2, J=1", "", []int{0, 1, 0, 0}, []int{5}}, // K is not a number
+		{"first=1, 2=3, last=2", "copy=2 3, sort=offset:100", []int{1, 3, 0, 2}, []int{102, 104}},
+		{"J=1, first=1", "copy=none", []int{1, 1, 0, 0}, nil},
+		{"none", "copy=0", []int{0, 0, 0, 0}, []int{5, 6, 7, 8}},
+	} {
+		cfg := config.DefaultConfig()
+		cfg.DefaultImageRules, cfg.DuplicateRules = tc.defaults, tc.duplicates
+		rules := testImageRules(t, cfg)
+		var isDef, sorts []int
+		for seq := 1; seq <= 4; seq++ {
+			isDef = append(isDef, rules.isDef(row, seq, 4))
+			if rules.duplicates(isDef[seq-1]) {
+				sorts = append(sorts, rules.duplicateSort(seq, 4, len(sorts)+1))
+			}
+		}
+		if fmt.Sprint(isDef) != fmt.Sprint(tc.isDef) || fmt.Sprint(sorts) != fmt.Sprint(tc.sorts) {
+			t.Errorf("%q / %q: is_def %v, copy sorts %v, want %v, %v", tc.defaults, tc.duplicates, isDef, sorts, tc.isDef, tc.sorts)
+		}
+	}
+
+	for _, tc := range []struct{ defaults, duplicates, want string }{
+		{"J", "", "is not source=is_def"},
+		{"J=-1", "", "is not source=is_def"},
+		{"J1=1", "", "needs a column, an image number, first or last"},
+		{"", "copy=a", "needs is_def values"},
+		{"", "sort=random", "use after or offset:N"},
+		{"", "sort=offset:0", "needs a positive offset"},
+		{"", "order=after", "unknown setting"},
+	} {
+		cfg := config.DefaultConfig()
+		cfg.DefaultImageRules, cfg.DuplicateRules = tc.defaults, tc.duplicates
+		if _, err := newImageRules(cfg); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q / %q: err = %v, want %q", tc.defaults, tc.duplicates, err, tc.want)
+		}
+	}
+}
+
+func TestSplitImageRules(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.DefaultImageRules = "last=1"
+	cfg.DuplicateRules = "copy=1, is_def=3, sort=offset:100"
+
+	var logged []string
+	if _, err := ValidateExcel(cfg, func(msg string) { logged = append(logged, msg) }); err != nil {
+		t.Fatal(err)
+	}
+	plan := strings.Join(logged, "\n")
+	for _, want := range []string{
+		"Image rules: is_def last=1; copies of is_def 1 as is_def 3, sort offset:100",
+		"D1 ITEM1: 2 images, image 2 is_def 1; copies: image 2 as sort 102",
+	} {
+		if !strings.Contains(plan, want) {
+			t.Errorf("validation log is missing %q:\n%s", want, plan)
+		}
+	}
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	entries := readManifest(t, cfg.WorkPath)
+	for key, want := range map[string][2]int{ // sort, is_def
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01.jpg"):     {1, 0},
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_02.jpg"):     {2, 1},
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_02_01.jpg"):  {102, 3},
+		smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_01_01.jpg"): {101, 3},
+	} {
+		if m, ok := entries[key]; !ok || m.Sort != want[0] || m.IsDef != want[1] {
+			t.Errorf("manifest[%s] = %+v, want sort %d, is_def %d", key, m, want[0], want[1])
+		}
+	}
+	if len(entries) != 6 {
+		t.Errorf("manifest has %d entries, want 6", len(entries))
+	}
+}
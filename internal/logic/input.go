@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ahMakerdir/internal/config"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Input file types RunSplit reads, all with the same column mapping
+var inputExtensions = []string{".xlsx", ".xlsm", ".csv", ".tsv"}
+
+func isInputFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range inputExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// findInputFile returns the path of the file to split: InputFile when set
+// (relative paths are inside the work path), otherwise the only workbook or
+// CSV/TSV file in the work path.
+func findInputFile(cfg config.Config) (string, error) {
+	dirPath := strings.TrimSpace(cfg.WorkPath)
+	if name := strings.TrimSpace(cfg.InputFile); name != "" {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dirPath, name)
+		}
+		if !isInputFile(name) {
+			return "", fmt.Errorf("unsupported input file %s, use one of %s", filepath.Base(name), strings.Join(inputExtensions, ", "))
+		}
+		if _, err := os.Stat(name); err != nil {
+			return "", fmt.Errorf("input file not found: %w", err)
+		}
+		return name, nil
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read work path: %w", err)
+	}
+	var found []string
+	for _, entry := range entries {
+		// Skip Excel lock files and backups like list.xlsx.bak
+		if !entry.IsDir() && isInputFile(entry.Name()) && !strings.HasPrefix(entry.Name(), "~$") {
+			found = append(found, entry.Name())
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no Excel or CSV file found in %s", dirPath)
+	case 1:
+		return filepath.Join(dirPath, found[0]), nil
+	}
+	return "", fmt.Errorf("found %d input files in %s (%s), choose one with InputFile", len(found), dirPath, strings.Join(found, ", "))
+}
+
+// openWorkbook opens the input file and returns its name. CSV and TSV files
+// are loaded into a workbook with a single sheet so they read like Excel.
+func openWorkbook(cfg config.Config) (string, *excelize.File, error) {
+	path, err := findInputFile(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var xlsx *excelize.File
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		xlsx, err = readDelimited(path, ',')
+	case ".tsv":
+		xlsx, err = readDelimited(path, '\t')
+	default:
+		xlsx, err = excelize.OpenFile(path)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	return filepath.Base(path), xlsx, nil
+}
+
+func readDelimited(path string, comma rune) (*excelize.File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // Excel saves UTF-8 CSV with a BOM
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	xlsx := excelize.NewFile()
+	for i, record := range records {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		values := make([]interface{}, len(record))
+		for j, v := range record {
+			values[j] = v
+		}
+		if err := xlsx.SetSheetRow("Sheet1", cell, &values); err != nil {
+			xlsx.Close()
+			return nil, err
+		}
+	}
+	return xlsx, nil
+}
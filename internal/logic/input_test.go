@@ -0,0 +1,63 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindInputFile(t *testing.T) {
+	cfg := newWorkPath(t)
+	write := func(name string) {
+		if err := os.WriteFile(filepath.Join(cfg.WorkPath, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Backups and lock files are not candidates
+	write("list.xlsx.bak")
+	write("~$list.xlsx")
+	if got, err := findInputFile(cfg); err != nil || filepath.Base(got) != "list.xlsx" {
+		t.Errorf("findInputFile = %q, %v; want list.xlsx", got, err)
+	}
+
+	write("old.csv")
+	if _, err := findInputFile(cfg); err == nil || !strings.Contains(err.Error(), "choose one with InputFile") {
+		t.Errorf("two candidates: err = %v, want ambiguity error", err)
+	}
+
+	cfg.InputFile = "old.csv"
+	if got, err := findInputFile(cfg); err != nil || got != filepath.Join(cfg.WorkPath, "old.csv") {
+		t.Errorf("InputFile: got %q, %v", got, err)
+	}
+	cfg.InputFile = "list.xlsx.bak"
+	if _, err := findInputFile(cfg); err == nil {
+		t.Error("InputFile with unsupported extension accepted")
+	}
+}
+
+func TestSplitFromTSV(t *testing.T) {
+	cfg := newWorkPath(t)
+	os.Remove(filepath.Join(cfg.WorkPath, "list.xlsx"))
+
+	var lines []string
+	for _, row := range sheetRows {
+		lines = append(lines, strings.Join(row, "\t"))
+	}
+	data := "\xef\xbb\xbf" + strings.Join(lines, "\r\n") + "\r\n"
+	if err := os.WriteFile(filepath.Join(cfg.WorkPath, "list.tsv"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	entries := readManifest(t, cfg.WorkPath)
+	if m := entries[smallKey("Brand_Season", "ITEM1_Red", "ITEM1_02.jpg")]; m.ExcelColD != "ITEM1" || m.IsDef != 2 || m.ColorPicFilename != "ITEM1_Color.png" {
+		t.Errorf("manifest[ITEM1_02.jpg] = %+v", m)
+	}
+	if m := entries[smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_01.jpg")]; m.ExcelRow != 2 || m.SourceImage != "a (3).jpg" {
+		t.Errorf("manifest[ITEM2_01.jpg] = %+v", m)
+	}
+}
@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// itemNamer gives out the base name of the files Split creates for a row,
+// e.g. ITEM1 for ITEM1_01.jpg and ITEM1_Color.png. Rows are named in the
+// order Split processes them: the first row of an item code keeps the code,
+// later rows with the same code get their color appended (ITEM1_Blue) and a
+// number when that name is taken too, so no two rows write the same files.
+// With a filename template a name is also taken when the file names it gives
+// clash with those of an earlier row, e.g. ITEM1 in color 02 would name its
+// first image ITEM1_02_01.jpg like the duplicate of ITEM1's second image.
+type itemNamer struct {
+	used  map[string]bool   // Lower-case names given out; Windows ignores case
+	first map[string]string // Item code, and code with color, -> where it was first used
+	files *filenameTemplate // Nil checks the names only
+	taken map[string]bool   // Lower-case file names of the names given out
+}
+
+func newItemNamer(files *filenameTemplate) *itemNamer {
+	return &itemNamer{used: make(map[string]bool), first: make(map[string]string), files: files, taken: make(map[string]bool)}
+}
+
+// name returns the base name for a row with the given code and color (column
+// G without slashes) and number of images, and records the row as where, for
+// firstUse and firstInFolder.
+func (n *itemNamer) name(code, color, where string, images int) string {
+	name := code
+	if _, ok := n.first[code]; ok && color != "" {
+		name = code + "_" + color
+	}
+	candidate := name
+	for i := 2; n.used[strings.ToLower(candidate)] || n.clashes(code, color, candidate, images); i++ {
+		candidate = fmt.Sprintf("%s_%d", name, i)
+	}
+	n.used[strings.ToLower(candidate)] = true
+	for _, file := range n.fileNames(code, color, candidate, images) {
+		n.taken[file] = true
+	}
+
+	if _, ok := n.first[code]; !ok {
+		n.first[code] = where
+	}
+	if _, ok := n.first[code+"\x00"+color]; !ok {
+		n.first[code+"\x00"+color] = where
+	}
+	return candidate
+}
+
+// clashes reports whether a row named base would give an image the name of
+// an image of an earlier row.
+func (n *itemNamer) clashes(code, color, base string, images int) bool {
+	for _, file := range n.fileNames(code, color, base, images) {
+		if n.taken[file] {
+			return true
+		}
+	}
+	return false
+}
+
+// fileNames returns the lower-case names, without extension, the template
+// gives the images of a row named base in every role.
+func (n *itemNamer) fileNames(code, color, base string, images int) []string {
+	if n.files == nil {
+		return nil
+	}
+	var names []string
+	for seq := 1; seq <= images; seq++ {
+		for _, role := range []string{roleImage, roleDefault, roleDuplicate} {
+			name := n.files.render(filenameVars{Item: base, Code: code, Color: color, Seq: seq, Role: role})
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return names
+}
+
+// firstUse returns where a row with the code was named first.
+func (n *itemNamer) firstUse(code string) (string, bool) {
+	where, ok := n.first[code]
+	return where, ok
+}
+
+// firstInFolder returns where a row with the code and color, which Split puts
+// in the same <code>_<color> folder, was named first.
+func (n *itemNamer) firstInFolder(code, color string) (string, bool) {
+	where, ok := n.first[code+"\x00"+color]
+	return where, ok
+}
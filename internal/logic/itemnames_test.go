@@ -0,0 +1,196 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ahMakerdir/internal/mockapi"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestItemNamer(t *testing.T) {
+	n := newItemNamer(nil)
+	for _, tc := range []struct{ code, color, want string }{
+		{"ITEM1", "Red", "ITEM1"},
+		{"ITEM1", "Blue", "ITEM1_Blue"},
+		{"item1_blue", "", "item1_blue_2"}, // Taken, ignoring case
+		{"ITEM1", "", "ITEM1_2"},
+		{"ITEM2", "", "ITEM2"},
+	} {
+		if got := n.name(tc.code, tc.color, "D1", 2); got != tc.want {
+			t.Errorf("name(%q, %q) = %q, want %q", tc.code, tc.color, got, tc.want)
+		}
+	}
+	if _, ok := n.firstInFolder("ITEM1", "Blue"); !ok {
+		t.Error("ITEM1/Blue not recorded")
+	}
+	if _, ok := n.firstInFolder("ITEM2", "Red"); ok {
+		t.Error("ITEM2/Red recorded")
+	}
+
+	// With the file names: ITEM1_02 would name its first image like the
+	// duplicate of ITEM1's second one, ITEM1_02_01
+	files, err := parseFilenameTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = newItemNamer(files)
+	for _, tc := range []struct {
+		code, color string
+		images      int
+		want        string
+	}{
+		{"ITEM1", "Red", 2, "ITEM1"},
+		{"ITEM1", "02", 1, "ITEM1_02_2"},
+		{"ITEM1", "03", 1, "ITEM1_03"},    // ITEM1 has no third image
+		{"ITEM1_01", "", 1, "ITEM1_01_2"}, // Another code, ITEM1_01_01 is still taken
+	} {
+		if got := n.name(tc.code, tc.color, "D1", tc.images); got != tc.want {
+			t.Errorf("name(%q, %q, %d) = %q, want %q", tc.code, tc.color, tc.images, got, tc.want)
+		}
+	}
+}
+
+func TestSplitNumericColor(t *testing.T) {
+	cfg := newWorkPath(t)
+	// ITEM1 again in color 02, whose first image would be ITEM1_02_01.jpg
+	xlsx, err := excelize.OpenFile(filepath.Join(cfg.WorkPath, "list.xlsx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := []interface{}{"Brand", "Season", "S100-1", "ITEM1", "", "", "02", "", "1", "1"}
+	if err := xlsx.SetSheetRow("Sheet1", "A3", &row); err != nil {
+		t.Fatal(err)
+	}
+	if err := xlsx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	xlsx.Close()
+	writeTestJPEG(t, filepath.Join(cfg.WorkPath, "org", "a (4).jpg"), 100, 140, nil)
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	names := make(map[string]string) // File name -> manifest key
+	for key := range readManifest(t, cfg.WorkPath) {
+		name := key[strings.LastIndex(key, "/")+1:]
+		if other, ok := names[name]; ok {
+			t.Errorf("%s and %s have the same name", other, key)
+		}
+		names[name] = key
+	}
+	for name, key := range map[string]string{
+		"ITEM1_02_01.jpg":   smallKey("Brand_Season", "ITEM1_Red", "ITEM1_02_01.jpg"),
+		"ITEM1_02_2_01.jpg": smallKey("Brand_Season", "ITEM1_02", "ITEM1_02_2_01.jpg"),
+	} {
+		if names[name] != key {
+			t.Errorf("%s is %q, want %s", name, names[name], key)
+		}
+	}
+}
+
+func TestSplitSharedItemCode(t *testing.T) {
+	cfg := newWorkPath(t)
+	// ITEM1 again in green, with a fourth picture
+	xlsx, err := excelize.OpenFile(filepath.Join(cfg.WorkPath, "list.xlsx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := []interface{}{"Brand", "Season", "S100-1", "ITEM1", "", "", "Green", "", "1", "1"}
+	if err := xlsx.SetSheetRow("Sheet1", "A3", &row); err != nil {
+		t.Fatal(err)
+	}
+	if err := xlsx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	xlsx.Close()
+	writeTestJPEG(t, filepath.Join(cfg.WorkPath, "org", "a (4).jpg"), 100, 140, nil)
+	cfg.ExcelWriteBack = true
+	_, apiSrv := startServers(t, &cfg, mockapi.Script{})
+
+	var logged []string
+	if _, err := RunSplit(cfg, func(msg string) { logged = append(logged, msg) }); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	if !strings.Contains(strings.Join(logged, "\n"), "files of this row are named ITEM1_Green_*") {
+		t.Error("shared item code not reported")
+	}
+	for _, f := range []string{"ITEM1_Red/SMALL/ITEM1_01.jpg", "ITEM1_Green/SMALL/ITEM1_Green_01.jpg", "OUT/ITEM1_S100.jpg", "OUT/ITEM1_Green_S100.jpg"} {
+		if _, err := os.Stat(filepath.Join(cfg.WorkPath, "Brand_Season", filepath.FromSlash(f))); err != nil {
+			t.Errorf("missing split output %s", f)
+		}
+	}
+	entries := readManifest(t, cfg.WorkPath)
+	if m := entries[smallKey("Brand_Season", "ITEM1_Green", "ITEM1_Green_01.jpg")]; m.ExcelColD != "ITEM1" || m.ExcelRow != 3 || m.IsDef != 1 {
+		t.Errorf("manifest[ITEM1_Green_01.jpg] = %+v", m)
+	}
+
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+	requests := apiSrv.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("API received %d requests, want 1", len(requests))
+	}
+	for _, name := range []string{"ITEM1_01.jpg", "ITEM1_Green_01.jpg"} {
+		if item, ok := requests[0].Payload[name]; !ok || item.ExcelColD != "ITEM1" {
+			t.Errorf("payload[%s] = %+v", name, item)
+		}
+	}
+	for key, meta := range readManifest(t, cfg.WorkPath) {
+		if meta.FtpPath == "" {
+			t.Errorf("manifest[%s] has no ftp_path", key)
+		}
+	}
+
+	// The report and the workbook copy keep the rows apart
+	report := readReport(t, cfg.WorkPath)
+	if len(report.Items) != 3 {
+		t.Fatalf("report has %d items, want one per row", len(report.Items))
+	}
+	for i, want := range []struct {
+		row   int
+		color string
+		files []string
+	}{
+		{1, "Red", []string{"ITEM1_01.jpg", "ITEM1_01_01.jpg", "ITEM1_02.jpg", "ITEM1_02_01.jpg", "ITEM1_Color.png"}},
+		{3, "Green", []string{"ITEM1_Green_01.jpg", "ITEM1_Green_01_01.jpg"}},
+	} {
+		it := report.Items[i*2] // Sorted by row, ITEM2 is row 2
+		var files []string
+		for _, f := range it.Files {
+			files = append(files, f.Filename)
+		}
+		if it.ItemCode != "ITEM1" || it.ExcelRow != want.row || it.Color != want.color || it.ApiResult != "success" ||
+			strings.Join(files, " ") != strings.Join(want.files, " ") {
+			t.Errorf("report item of row %d = %+v with files %v, want %s %v", want.row, it, files, want.color, want.files)
+		}
+	}
+
+	copies, _ := filepath.Glob(filepath.Join(cfg.WorkPath, "ApiResults", "list_result_*.xlsx"))
+	if len(copies) != 1 {
+		t.Fatalf("found %d workbook copies, want 1", len(copies))
+	}
+	xlsx, err = excelize.OpenFile(copies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xlsx.Close()
+	rows, err := xlsx.GetRows("Sheet1")
+	if err != nil || len(rows) != 4 {
+		t.Fatalf("copy has %d rows, %v; want header and 3 items", len(rows), err)
+	}
+	for excelRow, want := range map[int]string{1: "2|ITEM1_01.jpg|success", 3: "1|ITEM1_Green_01.jpg|success"} {
+		row := rows[excelRow][12:] // Below the added header row
+		if got := row[0] + "|" + pathBase(row[3]) + "|" + row[4]; got != want {
+			t.Errorf("row %d status = %q, want %q", excelRow, row, want)
+		}
+	}
+}
+
+func pathBase(p string) string {
+	return p[strings.LastIndex(p, "/")+1:]
+}
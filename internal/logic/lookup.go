@@ -0,0 +1,169 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// colorPicExtensions are tried in order when column L has no extension.
+var colorPicExtensions = []string{".jpg", ".png"}
+
+// fileLookup finds files of one source directory by name. Names typed into the
+// sheet often differ from the file only in case, full-width characters or
+// stray spaces, so those differences are ignored when there is no exact match.
+type fileLookup struct {
+	dir   string
+	names []string          // File names as listed
+	exact map[string]string // name -> name
+	loose map[string]string // normalizeName(name) -> name
+}
+
+// newFileLookup lists dir. A missing or unreadable dir gives an empty lookup.
+func newFileLookup(dir string) *fileLookup {
+	names, _ := listFiles(dir)
+	return fileLookupOf(dir, names)
+}
+
+func fileLookupOf(dir string, names []string) *fileLookup {
+	l := &fileLookup{dir: dir, names: names, exact: make(map[string]string), loose: make(map[string]string)}
+	for _, name := range names {
+		l.exact[name] = name
+		if _, ok := l.loose[normalizeName(name)]; !ok {
+			l.loose[normalizeName(name)] = name
+		}
+	}
+	return l
+}
+
+// listFiles returns the names of the files in dir.
+func listFiles(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// normalizeName folds full-width to half-width characters (NFKC), case and
+// surrounding spaces.
+func normalizeName(s string) string {
+	return strings.ToLower(strings.TrimSpace(norm.NFKC.String(s)))
+}
+
+// get returns the path of the file called name, or "".
+func (l *fileLookup) get(name string) string {
+	if name == "" {
+		return ""
+	}
+	if found, ok := l.exact[name]; ok {
+		return filepath.Join(l.dir, found)
+	}
+	if found, ok := l.loose[normalizeName(name)]; ok {
+		return filepath.Join(l.dir, found)
+	}
+	return ""
+}
+
+// find returns the path of name as given when it has an extension, otherwise
+// of the first of name+ext that exists.
+func (l *fileLookup) find(name string, exts []string) string {
+	name = strings.TrimSpace(name)
+	if filepath.Ext(norm.NFKC.String(name)) != "" {
+		return l.get(name)
+	}
+	for _, ext := range exts {
+		if path := l.get(name + ext); path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+// suggest returns up to n file names closest to name, nearest first, for the
+// "not found" messages. Files that are too different are left out.
+func (l *fileLookup) suggest(name string, n int) []string {
+	target := []rune(trimExt(normalizeName(name)))
+	if len(target) == 0 {
+		return nil
+	}
+	limit := len(target) / 3
+	if limit < 2 {
+		limit = 2
+	}
+
+	type candidate struct {
+		name string
+		dist int
+	}
+	var candidates []candidate
+	for _, file := range l.names {
+		base := []rune(trimExt(normalizeName(file)))
+		d := editDistance(target, base)
+		// A file named after a prefix of the style, e.g. S100 for S100-1A
+		if len(base) >= 3 && strings.HasPrefix(string(target), string(base)) && d > 1 {
+			d = 1
+		}
+		if d <= limit {
+			candidates = append(candidates, candidate{file, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dist != candidates[j].dist {
+			return candidates[i].dist < candidates[j].dist
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var names []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		names = append(names, candidates[i].name)
+	}
+	return names
+}
+
+// suggestion formats suggest for a message: " (closest: a.jpg, b.jpg)" or "".
+func (l *fileLookup) suggestion(name string) string {
+	names := l.suggest(name, 3)
+	if len(names) == 0 {
+		return ""
+	}
+	return " (closest: " + strings.Join(names, ", ") + ")"
+}
+
+func trimExt(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// editDistance is the Levenshtein distance of a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
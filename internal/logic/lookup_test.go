@@ -0,0 +1,73 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileLookup(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Navy01.jpg", "navy02.png", "S1001.jpg", "S1002.jpg", "Red.PNG", "小花.jpg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := newFileLookup(dir)
+
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"Navy01", "Navy01.jpg"},
+		{"navy01 ", "Navy01.jpg"}, // Case and trailing space
+		{"ＮＡＶＹ０１", "Navy01.jpg"},  // Full-width
+		{"navy02", "navy02.png"},  // .jpg first, then .png
+		{"red.png", "Red.PNG"},    // Extension as given, any case
+		{"Red.jpg", ""},           // Only the given extension
+		{"小花", "小花.jpg"},
+		{"Navy1", ""},
+	} {
+		got := ""
+		if path := l.find(tc.name, colorPicExtensions); path != "" {
+			got = filepath.Base(path)
+		}
+		if got != tc.want {
+			t.Errorf("find(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if got := l.suggest("Navy1", 3); !reflect.DeepEqual(got, []string{"Navy01.jpg", "navy02.png"}) {
+		t.Errorf("suggest(Navy1) = %v", got)
+	}
+	if got := l.suggest("S1003", 3); !reflect.DeepEqual(got, []string{"S1001.jpg", "S1002.jpg"}) {
+		t.Errorf("suggest(S1003) = %v", got)
+	}
+	if got := l.suggest("Completely different", 3); len(got) != 0 {
+		t.Errorf("suggest of an unrelated name = %v", got)
+	}
+}
+
+func TestValidateSuggestsNames(t *testing.T) {
+	cfg := newWorkPath(t)
+	// "SW1 " in the sheet still finds sw1.png, "sw2" suggests it
+	rows := [][]string{
+		{"Brand", "Season", "S100-1", "ITEM1", "", "", "Red", "", "2", "1", "2", "ＳＷ１ "},
+		{"Brand", "Season", "S200-1", "ITEM2", "", "", "Blue", "", "1", "1", "", "sw2"},
+	}
+	issues := validateRows(cfg, "", rows, 3, newItemNamer(nil), &sizeTableResolver{dir: cfg.SizeTablePath, rules: []sizeTableRule{{kind: SizeTableRuleStyle}}, exts: []string{".jpg"}}, newFileLookup(cfg.ColorPicPath), testImageRules(t, cfg))
+	if len(issues) != 1 || issues[0].cell() != "L2" || !strings.Contains(issues[0].Message, "closest: sw1.png") {
+		t.Errorf("issues = %v, want a missing sw2 suggesting sw1.png", issues)
+	}
+
+	rows[1][2] = "S2000-1"
+	issues = validateRows(cfg, "", rows[1:], 1, newItemNamer(nil), &sizeTableResolver{dir: cfg.SizeTablePath, rules: []sizeTableRule{{kind: SizeTableRuleStyle}}, exts: []string{".jpg"}}, newFileLookup(cfg.ColorPicPath), testImageRules(t, cfg))
+	found := false
+	for _, issue := range issues {
+		found = found || issue.Column == "C" && strings.Contains(issue.Message, "closest: S200.jpg")
+	}
+	if !found {
+		t.Errorf("issues = %v, want a missing size table suggesting S200.jpg", issues)
+	}
+}
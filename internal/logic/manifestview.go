@@ -0,0 +1,149 @@
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ahMakerdir/internal/manifest"
+)
+
+// ImageMetadata is one manifest file together with the values of its item,
+// as used by the upload, the run report and the Excel write-back.
+type ImageMetadata struct {
+	Type              string // manifest.TypeImage or manifest.TypeColor
+	ExcelColD         string
+	FtpPath           string
+	Sort              int
+	IsDef             int
+	ColorPicFilename  string
+	ColorPicGenerated bool // Color file made by SwatchGenerate
+	Folder            string
+	Color             string
+	Sheet             string // Set when several sheets are processed
+	ExcelRow          int
+	SourceImage       string
+	Warnings          []string // Problems found for the row
+}
+
+// manifestEntries returns the files of m by key, the path of their SMALL copy
+// relative to the work path (see entryKey). A nil manifest has none.
+func manifestEntries(m *manifest.Manifest) map[string]ImageMetadata {
+	entries := make(map[string]ImageMetadata)
+	if m == nil {
+		return entries
+	}
+	for _, it := range m.Items {
+		for _, f := range it.Files {
+			meta := ImageMetadata{
+				Type:              f.Type,
+				ExcelColD:         it.Code,
+				Sort:              f.Sort,
+				IsDef:             f.IsDef,
+				ColorPicFilename:  f.ColorPic,
+				ColorPicGenerated: f.Generated,
+				Folder:            it.Folder,
+				Color:             it.Color,
+				Sheet:             it.Sheet,
+				ExcelRow:          it.ExcelRow,
+				SourceImage:       f.SourceImage,
+				Warnings:          it.Warnings,
+			}
+			if f.Upload != nil {
+				meta.FtpPath = f.Upload.FtpPath
+			}
+			entries[f.Key()] = meta
+		}
+	}
+	return entries
+}
+
+// entryKey returns the manifest key of a file in the work path. Either path
+// may be relative to the current directory.
+func entryKey(workPath, localPath string) string {
+	base, err1 := filepath.Abs(strings.TrimSpace(workPath))
+	target, err2 := filepath.Abs(localPath)
+	if err1 != nil || err2 != nil {
+		return filepath.Base(localPath)
+	}
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return filepath.Base(localPath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// loadManifest reads the manifest of the work path. A missing manifest
+// returns nil without an error; an unreadable or invalid one is an error.
+func loadManifest(workPath string) (*manifest.Manifest, error) {
+	m, err := manifest.Load(manifest.Path(workPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	if m.Migrated {
+		locateSmallCopies(workPath, m)
+	}
+	return m, nil
+}
+
+// locateSmallCopies gives files of a migrated manifest without a SMALL
+// rendition the copy found at <folder>/<item dir>/SMALL/<name> in workPath.
+// Version 1 manifests from before the folder and color were recorded only
+// know the file name; a name found in several item folders of the code stays
+// unresolved.
+func locateSmallCopies(workPath string, m *manifest.Manifest) {
+	workPath = strings.TrimSpace(workPath)
+	found := make(map[string][]string) // Name -> SMALL copies relative to workPath
+	folders, _ := os.ReadDir(workPath)
+	for _, folder := range folders {
+		if !folder.IsDir() {
+			continue
+		}
+		itemDirs, _ := os.ReadDir(filepath.Join(workPath, folder.Name()))
+		for _, itemDir := range itemDirs {
+			if !itemDir.IsDir() {
+				continue
+			}
+			rel := filepath.Join(folder.Name(), itemDir.Name(), manifest.RenditionSmall)
+			names, _ := listFiles(filepath.Join(workPath, rel))
+			for _, name := range names {
+				found[name] = append(found[name], filepath.Join(rel, name))
+			}
+		}
+	}
+
+	for _, it := range m.Items {
+		for _, f := range it.Files {
+			if f.Rendition(manifest.RenditionSmall) != nil {
+				continue
+			}
+			// Item folders are named <code>_<color>
+			var matches []string
+			for _, rel := range found[f.Name] {
+				if strings.HasPrefix(filepath.Base(filepath.Dir(filepath.Dir(rel))), it.Code+"_") {
+					matches = append(matches, rel)
+				}
+			}
+			if len(matches) == 1 {
+				f.AddRendition(manifest.RenditionSmall, workPath, matches[0])
+			}
+		}
+	}
+}
+
+// addRendition records a copy made by Split, logging files that cannot be read.
+func addRendition(f *manifest.File, name, workPath, path string, progress func(string)) {
+	rel, err := filepath.Rel(workPath, path)
+	if err == nil {
+		err = f.AddRendition(name, workPath, rel)
+	}
+	if err != nil {
+		progress(fmt.Sprintf("Warning: Could not record %s in the manifest: %v", filepath.Base(path), err))
+	}
+}
@@ -0,0 +1,719 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"ahMakerdir/internal/api"
+	"ahMakerdir/internal/config"
+	"ahMakerdir/internal/ftptest"
+	"ahMakerdir/internal/manifest"
+	"ahMakerdir/internal/mockapi"
+
+	"github.com/xuri/excelize/v2"
+)
+
+var testICCProfile = bytes.Repeat([]byte("ahMakeDir test ICC profile "), 40)
+
+// sheetRows is the test workbook: two items, ITEM1 with two images and a
+// color pic, ITEM2 with one image. Columns A to L as read by RunSplit.
+var sheetRows = [][]string{
+	{"Brand", "Season", "S100-1", "ITEM1", "", "", "Red", "", "2", "1", "2", "sw1"},
+	{"Brand", "Season", "S200-1", "ITEM2", "", "", "Blue", "", "1", "1", "", ""},
+}
+
+// newWorkPath builds a work path with a workbook, source pictures carrying an
+// ICC profile, size tables and a color pic, and returns a config pointing at it.
+func newWorkPath(t *testing.T) config.Config {
+	t.Helper()
+	root := t.TempDir()
+	workPath := filepath.Join(root, "work")
+	sizeTablePath := filepath.Join(root, "size")
+	colorPicPath := filepath.Join(root, "color")
+	for _, dir := range []string{filepath.Join(workPath, "org"), sizeTablePath, colorPicPath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	xlsx := excelize.NewFile()
+	for i, row := range sheetRows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		values := make([]interface{}, len(row))
+		for j, v := range row {
+			values[j] = v
+		}
+		if err := xlsx.SetSheetRow("Sheet1", cell, &values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := xlsx.SaveAs(filepath.Join(workPath, "list.xlsx")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		writeTestJPEG(t, filepath.Join(workPath, "org", "a ("+string(rune('0'+i))+").jpg"), 100, 140, testICCProfile)
+	}
+	writeTestJPEG(t, filepath.Join(sizeTablePath, "S100.jpg"), 20, 20, nil)
+	writeTestJPEG(t, filepath.Join(sizeTablePath, "S200.jpg"), 20, 20, nil)
+
+	f, err := os.Create(filepath.Join(colorPicPath, "sw1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	f.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.WorkPath = workPath
+	cfg.PictureDirName = "org"
+	cfg.SizeTablePath = sizeTablePath
+	cfg.ColorPicPath = colorPicPath
+	cfg.Width = "50"
+	cfg.Height = "70"
+	cfg.ApiRetryDelaySec = 1
+	return cfg
+}
+
+func writeTestJPEG(t *testing.T, path string, w, h int, profile []byte) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if profile != nil {
+		out := new(bytes.Buffer)
+		if err := embedICCProfile(out, buf, profile); err != nil {
+			t.Fatal(err)
+		}
+		data = out.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startServers runs the in-memory FTP server and the mock API and points cfg at them.
+func startServers(t *testing.T, cfg *config.Config, script mockapi.Script) (*ftptest.Server, *mockapi.Server) {
+	t.Helper()
+	ftpSrv, err := ftptest.NewServer("tester", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ftpSrv.Close() })
+	cfg.FtpHost, cfg.FtpPort = ftpSrv.Addr()
+	cfg.FtpUser = "tester"
+	cfg.FtpPassword = "secret"
+
+	apiSrv := mockapi.New(script)
+	url, err := apiSrv.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { apiSrv.Close() })
+	cfg.ApiUrl = url
+	return ftpSrv, apiSrv
+}
+
+func testLog(t *testing.T) func(string) {
+	return func(msg string) { t.Log(msg) }
+}
+
+// smallKey returns the manifest key of a file Split copied to folder/itemDir/SMALL.
+func smallKey(folder, itemDir, name string) string {
+	return folder + "/" + itemDir + "/SMALL/" + name
+}
+
+func readManifest(t *testing.T, workPath string) map[string]ImageMetadata {
+	t.Helper()
+	m, err := manifest.Load(manifest.Path(workPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifestEntries(m)
+}
+
+func TestPipelineSplitCompressUpload(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.VerifyUpload = true
+	ftpSrv, apiSrv := startServers(t, &cfg, mockapi.Script{NotFoundSNs: []string{"ITEM2"}})
+
+	// Split
+	smallDirs, err := RunSplit(cfg, testLog(t))
+	if err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	if len(smallDirs) != 2 {
+		t.Fatalf("got %d SMALL dirs, want 2", len(smallDirs))
+	}
+
+	level1 := filepath.Join(cfg.WorkPath, "Brand_Season")
+	for _, f := range []string{
+		"ITEM1_Red/BIG/ITEM1_01.jpg",
+		"ITEM1_Red/BIG/ITEM1_02.jpg",
+		"ITEM1_Red/SMALL/ITEM1_01.jpg",
+		"ITEM1_Red/SMALL/ITEM1_01_01.jpg",
+		"ITEM1_Red/SMALL/ITEM1_02.jpg",
+		"ITEM1_Red/SMALL/ITEM1_02_01.jpg",
+		"ITEM1_Red/SMALL/ITEM1_Color.png",
+		"ITEM2_Blue/SMALL/ITEM2_01.jpg",
+		"ITEM2_Blue/SMALL/ITEM2_01_01.jpg",
+		"OUT/ITEM1_01.jpg",
+		"OUT/ITEM1_S100.jpg",
+		"OUT/ITEM2_S200.jpg",
+	} {
+		if _, err := os.Stat(filepath.Join(level1, filepath.FromSlash(f))); err != nil {
+			t.Errorf("missing split output %s", f)
+		}
+	}
+
+	entries := readManifest(t, cfg.WorkPath)
+	want := map[string]ImageMetadata{
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01.jpg"):     {ExcelColD: "ITEM1", Sort: 1, IsDef: 1, ColorPicFilename: "ITEM1_Color.png"},
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01_01.jpg"):  {ExcelColD: "ITEM1", Sort: 3, IsDef: 0, ColorPicFilename: "ITEM1_Color.png"},
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_02.jpg"):     {ExcelColD: "ITEM1", Sort: 2, IsDef: 2, ColorPicFilename: "ITEM1_Color.png"},
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_02_01.jpg"):  {ExcelColD: "ITEM1", Sort: 4, IsDef: 0, ColorPicFilename: "ITEM1_Color.png"},
+		smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_01.jpg"):    {ExcelColD: "ITEM2", Sort: 1, IsDef: 1},
+		smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_01_01.jpg"): {ExcelColD: "ITEM2", Sort: 2, IsDef: 0},
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_Color.png"):  {Type: manifest.TypeColor, ExcelColD: "ITEM1"},
+	}
+	if len(entries) != len(want) {
+		t.Errorf("manifest has %d entries, want %d", len(entries), len(want))
+	}
+	for name, w := range want {
+		got, ok := entries[name]
+		if !ok {
+			t.Errorf("manifest missing %s", name)
+			continue
+		}
+		if w.Type == "" {
+			w.Type = manifest.TypeImage
+		}
+		if got.Type != w.Type || got.ExcelColD != w.ExcelColD || got.Sort != w.Sort || got.IsDef != w.IsDef || got.ColorPicFilename != w.ColorPicFilename {
+			t.Errorf("manifest[%s] = %+v, want %+v", name, got, w)
+		}
+	}
+
+	// Compress
+	if err := RunCompress(smallDirs, cfg, testLog(t)); err != nil {
+		t.Fatalf("RunCompress: %v", err)
+	}
+	small := filepath.Join(level1, "ITEM1_Red", "SMALL", "ITEM1_01.jpg")
+	f, err := os.Open(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgCfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imgCfg.Width != 50 || imgCfg.Height != 70 {
+		t.Errorf("compressed size %dx%d, want 50x70", imgCfg.Width, imgCfg.Height)
+	}
+	f, _ = os.Open(small)
+	profile, err := extractICCProfile(f)
+	f.Close()
+	if err != nil || !bytes.Equal(profile, testICCProfile) {
+		t.Errorf("ICC profile not preserved (err %v, %d bytes)", err, len(profile))
+	}
+
+	// Upload
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+
+	remoteDir := "/GoodsColor/" + time.Now().Format("20060102") + "/"
+	wantRemote := []string{
+		remoteDir + "ITEM1_01.jpg",
+		remoteDir + "ITEM1_01_01.jpg",
+		remoteDir + "ITEM1_02.jpg",
+		remoteDir + "ITEM1_02_01.jpg",
+		remoteDir + "ITEM1_Color.png",
+	}
+	if got := ftpSrv.Names(); strings.Join(got, ",") != strings.Join(wantRemote, ",") {
+		t.Errorf("remote files = %v, want %v (ITEM2 should be cleaned up)", got, wantRemote)
+	}
+	localData, _ := os.ReadFile(small)
+	if remote := ftpSrv.Files()[remoteDir+"ITEM1_01.jpg"]; !bytes.Equal(remote, localData) {
+		t.Errorf("remote ITEM1_01.jpg differs from the compressed file")
+	}
+
+	requests := apiSrv.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("API received %d requests, want 1", len(requests))
+	}
+	payload := requests[0].Payload
+	if len(payload) != 6 {
+		t.Errorf("payload has %d images, want 6", len(payload))
+	}
+	item := payload["ITEM1_02.jpg"]
+	if item.FtpPath != "/image"+remoteDir+"ITEM1_02.jpg" || item.ColorPic != "/image"+remoteDir+"ITEM1_Color.png" || item.IsDef != 2 || item.Sort != 2 {
+		t.Errorf("payload[ITEM1_02.jpg] = %+v", item)
+	}
+	if requests[0].IdempotencyKey == "" {
+		t.Error("request without Idempotency-Key")
+	}
+
+	entries = readManifest(t, cfg.WorkPath)
+	if got := entries[smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01.jpg")].FtpPath; got != "/image"+remoteDir+"ITEM1_01.jpg" {
+		t.Errorf("manifest ftp_path = %q", got)
+	}
+	if got := entries[smallKey("Brand_Season", "ITEM1_Red", "ITEM1_Color.png")].FtpPath; got != item.ColorPic {
+		t.Errorf("color pic ftp_path = %q, want %q", got, item.ColorPic)
+	}
+
+	results, _ := filepath.Glob(filepath.Join(cfg.WorkPath, "ApiResults", "*.json"))
+	var names []string
+	for _, r := range results {
+		names = append(names, strings.SplitN(filepath.Base(r), "_2", 2)[0])
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "api_payload,run_report,success_goods_color_ids,success_goods_color_pic_ids" {
+		t.Errorf("ApiResults files = %v", names)
+	}
+
+	report := readReport(t, cfg.WorkPath)
+	if len(report.Items) != 2 {
+		t.Fatalf("report has %d items, want 2", len(report.Items))
+	}
+	first, second := report.Items[0], report.Items[1]
+	if first.ItemCode != "ITEM1" || first.ExcelRow != 1 || first.ApiResult != "success" || len(first.Files) != 5 {
+		t.Errorf("report item 1 = %+v", first)
+	}
+	colorFiles := 0
+	for _, f := range first.Files {
+		if f.Type == manifest.TypeColor {
+			colorFiles++
+		}
+	}
+	if colorFiles != 1 {
+		t.Errorf("report item 1 has %d color pics, want 1", colorFiles)
+	}
+	if second.ItemCode != "ITEM2" || second.ApiResult != "not_found" {
+		t.Errorf("report item 2 = %+v", second)
+	}
+	for _, f := range second.Files {
+		if f.Status != fileRemoved {
+			t.Errorf("report file %s status = %q, want %q", f.Filename, f.Status, fileRemoved)
+		}
+	}
+}
+
+func readReport(t *testing.T, workPath string) RunReport {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(workPath, "ApiResults", "run_report_*.json"))
+	if len(files) != 1 {
+		t.Fatalf("found %d run reports, want 1", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestUploadRollsBackWhenAPIFails(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.TransactionalUpload = true
+	cfg.ApiRetries = 1
+	ftpSrv, apiSrv := startServers(t, &cfg, mockapi.Script{HTTPStatus: 500, Message: "maintenance"})
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+
+	if got := len(apiSrv.Requests()); got != 2 {
+		t.Errorf("API received %d requests, want 2 (one retry)", got)
+	}
+	if got := ftpSrv.Names(); len(got) != 0 {
+		t.Errorf("remote files left after rollback: %v", got)
+	}
+	for name, meta := range readManifest(t, cfg.WorkPath) {
+		if meta.FtpPath != "" {
+			t.Errorf("manifest[%s] still has ftp_path %s", name, meta.FtpPath)
+		}
+	}
+}
+
+func TestUploadTransactionalSendsOneChunk(t *testing.T) {
+	for _, script := range []mockapi.Script{
+		{RejectSNs: []string{"ITEM2"}},
+		{Version: "2"}, // Not understood, the backend may or may not have stored it
+	} {
+		cfg := newWorkPath(t)
+		cfg.TransactionalUpload = true
+		cfg.ApiChunkSize = 1
+		ftpSrv, apiSrv := startServers(t, &cfg, script)
+
+		if _, err := RunSplit(cfg, testLog(t)); err != nil {
+			t.Fatalf("RunSplit: %v", err)
+		}
+		// Uploaded, but never part of the payload
+		writeTestJPEG(t, filepath.Join(cfg.WorkPath, "Brand_Season", "ITEM1_Red", "SMALL", "extra.jpg"), 50, 70, nil)
+		if err := RunUpload(cfg, testLog(t)); err != nil {
+			t.Fatalf("RunUpload: %v", err)
+		}
+
+		// ITEM1 is not accepted on its own and left without files
+		requests := apiSrv.Requests()
+		if len(requests) != 1 || len(requests[0].Payload) != 6 {
+			t.Fatalf("script %+v: API received %d requests, want all 6 images in one", script, len(requests))
+		}
+		if got := ftpSrv.Names(); len(got) != 0 {
+			t.Errorf("script %+v: remote files left after rollback: %v", script, got)
+		}
+		for name, meta := range readManifest(t, cfg.WorkPath) {
+			if meta.FtpPath != "" {
+				t.Errorf("script %+v: manifest[%s] still has ftp_path %s", script, name, meta.FtpPath)
+			}
+		}
+	}
+}
+
+func TestReplayReportsRejectedImages(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.ApiChunkSize = 1
+	cfg.ApiRetries = 0
+	_, apiSrv := startServers(t, &cfg, mockapi.Script{})
+	payloadFile, err := savePayload(cfg.WorkPath, api.Payload{
+		"ITEM1_01.jpg": {ExcelColD: "ITEM1", FtpPath: "/image/GoodsColor/ITEM1_01.jpg", Sort: 1, IsDef: 1},
+		"ITEM2_01.jpg": {ExcelColD: "ITEM2", FtpPath: "/image/GoodsColor/ITEM2_01.jpg", Sort: 1, IsDef: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		script mockapi.Script
+		want   string
+	}{
+		{mockapi.Script{}, ""},
+		{mockapi.Script{RejectSNs: []string{"ITEM2"}}, "1 of 2 images rejected"},
+		{mockapi.Script{HTTPStatus: 500}, "all 2 images rejected by the API, no IDs were saved"},
+	} {
+		apiSrv.SetScript(tc.script)
+		err := RunReplay(cfg, payloadFile, testLog(t))
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("script %+v: RunReplay = %v, want %q", tc.script, err, tc.want)
+		}
+	}
+}
+
+func TestUploadStopsOnInvalidManifest(t *testing.T) {
+	cfg := newWorkPath(t)
+	ftpSrv, apiSrv := startServers(t, &cfg, mockapi.Script{})
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	m, err := manifest.Load(manifest.Path(cfg.WorkPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, f, _ := m.Lookup(smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01.jpg"))
+	if f.Rendition(manifest.RenditionSmall) == nil {
+		t.Errorf("ITEM1_01.jpg has no SMALL rendition: %+v", f.Renditions)
+	}
+	f.ColorPic = "ITEM9_Color.png"
+	data, _ := json.Marshal(m)
+	if err := os.WriteFile(manifest.Path(cfg.WorkPath), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = RunUpload(cfg, testLog(t))
+	if err == nil || !strings.Contains(err.Error(), "ITEM9_Color.png") {
+		t.Fatalf("RunUpload err = %v, want the invalid color pic reference", err)
+	}
+	if got := ftpSrv.Names(); len(got) != 0 {
+		t.Errorf("files uploaded from an invalid manifest: %v", got)
+	}
+	if got := len(apiSrv.Requests()); got != 0 {
+		t.Errorf("API received %d requests", got)
+	}
+	if err := RunCompress(nil, cfg, testLog(t)); err == nil {
+		t.Error("RunCompress accepted the invalid manifest")
+	}
+}
+
+func TestUploadBaselineManifest(t *testing.T) {
+	cfg := newWorkPath(t)
+	ftpSrv, apiSrv := startServers(t, &cfg, mockapi.Script{})
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	// manifest.json exactly as the first versions wrote it: keyed by file name, no folder or color
+	baseline := `{
+  "ITEM1_01.jpg": {"excel_col_d": "ITEM1", "sort": 1, "is_def": 1, "color_pic_filename": "ITEM1_Color.png"},
+  "ITEM1_02.jpg": {"excel_col_d": "ITEM1", "sort": 2, "is_def": 0, "color_pic_filename": "ITEM1_Color.png"},
+  "ITEM2_01.jpg": {"excel_col_d": "ITEM2", "sort": 1, "is_def": 1, "ftp_path": "/image/old/ITEM2_01.jpg"}
+}`
+	if err := os.WriteFile(manifest.Path(cfg.WorkPath), []byte(baseline), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+
+	requests := apiSrv.Requests()
+	if len(requests) != 1 || len(requests[0].Payload) != 3 {
+		t.Fatalf("API received %d requests, want one with the 3 images", len(requests))
+	}
+	colorPic := ""
+	for _, name := range ftpSrv.Names() {
+		if strings.HasSuffix(name, "/ITEM1_Color.png") {
+			colorPic = "/image" + name
+		}
+	}
+	for name, want := range map[string]api.Item{
+		"ITEM1_01.jpg": {ExcelColD: "ITEM1", Sort: 1, IsDef: 1, ColorPic: colorPic},
+		"ITEM1_02.jpg": {ExcelColD: "ITEM1", Sort: 2, IsDef: 0, ColorPic: colorPic},
+		"ITEM2_01.jpg": {ExcelColD: "ITEM2", Sort: 1, IsDef: 1},
+	} {
+		got := requests[0].Payload[name]
+		want.FtpPath = got.FtpPath
+		if got != want || !strings.HasSuffix(got.FtpPath, "/"+name) {
+			t.Errorf("payload[%s] = %+v, want %+v", name, got, want)
+		}
+	}
+	if colorPic == "" {
+		t.Errorf("color pic not uploaded: %v", ftpSrv.Names())
+	}
+
+	// Saved in the new format, with the SMALL copies found in the work path
+	entries := readManifest(t, cfg.WorkPath)
+	for _, key := range []string{
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01.jpg"),
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_Color.png"),
+		smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_01.jpg"),
+	} {
+		if meta, ok := entries[key]; !ok || meta.FtpPath == "" {
+			t.Errorf("manifest[%s] = %+v, %v, want it uploaded", key, meta, ok)
+		}
+	}
+}
+
+func TestUploadRollsBackFilesWrittenTwice(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.TransactionalUpload = true
+	cfg.ApiRetries = 0
+	ftpSrv, _ := startServers(t, &cfg, mockapi.Script{HTTPStatus: 500})
+	remoteDir := "GoodsColor/" + time.Now().Format("20060102") + "/"
+	live := []byte("image that was live before the run")
+	ftpSrv.PutFile(remoteDir+"ITEM1_01.jpg", live)
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	// Both go to the same remote path, the second one overwrites the first
+	for _, dir := range []string{"ITEM1_Red", "ITEM2_Blue"} {
+		writeTestJPEG(t, filepath.Join(cfg.WorkPath, "Brand_Season", dir, "SMALL", "extra.jpg"), 50, 70, nil)
+	}
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+
+	// Only the file that was there before the run is kept
+	if got := ftpSrv.Names(); len(got) != 1 || got[0] != "/"+remoteDir+"ITEM1_01.jpg" {
+		t.Errorf("remote files after rollback = %v, want only the overwritten ITEM1_01.jpg", got)
+	}
+}
+
+func TestUploadRejectsUnknownCollisionPolicy(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.RemoteCollisionPolicy = "replace"
+	ftpSrv, _ := startServers(t, &cfg, mockapi.Script{})
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	if err := RunUpload(cfg, testLog(t)); err == nil || !strings.Contains(err.Error(), `unknown remote collision policy "replace"`) {
+		t.Fatalf("RunUpload err = %v, want the unknown policy", err)
+	}
+	if got := ftpSrv.Names(); len(got) != 0 {
+		t.Errorf("files uploaded with an unknown policy: %v", got)
+	}
+}
+
+func TestUploadRenamesOnCollision(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.RemoteCollisionPolicy = CollisionRename
+	ftpSrv, apiSrv := startServers(t, &cfg, mockapi.Script{})
+
+	remoteDir := "GoodsColor/" + time.Now().Format("20060102") + "/"
+	live := []byte("image another product already uses")
+	ftpSrv.PutFile(remoteDir+"ITEM1_01.jpg", live)
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+
+	files := ftpSrv.Files()
+	if !bytes.Equal(files["/"+remoteDir+"ITEM1_01.jpg"], live) {
+		t.Error("existing remote file was overwritten")
+	}
+	requests := apiSrv.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("API received %d requests, want 1", len(requests))
+	}
+	renamed := strings.TrimPrefix(requests[0].Payload["ITEM1_01.jpg"].FtpPath, "/image/")
+	if !strings.HasPrefix(renamed, remoteDir+"ITEM1_01_") || files["/"+renamed] == nil {
+		t.Errorf("payload points to %q, want an uploaded renamed file", renamed)
+	}
+	if got := readManifest(t, cfg.WorkPath)[smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01.jpg")].FtpPath; got != "/image/"+renamed {
+		t.Errorf("manifest ftp_path = %q, want /image/%s", got, renamed)
+	}
+}
+
+func TestUploadWritesResultsToExcelCopy(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.ExcelWriteBack = true
+	startServers(t, &cfg, mockapi.Script{NotFoundSNs: []string{"ITEM2"}})
+	os.Remove(filepath.Join(cfg.SizeTablePath, "S200.jpg"))
+
+	RunSplit(cfg, testLog(t)) // Reports the missing size table
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+
+	copies, _ := filepath.Glob(filepath.Join(cfg.WorkPath, "ApiResults", "list_result_*.xlsx"))
+	if len(copies) != 1 {
+		t.Fatalf("found %d workbook copies, want 1", len(copies))
+	}
+	xlsx, err := excelize.OpenFile(copies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xlsx.Close()
+	rows, err := xlsx.GetRows("Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("copy has %d rows, want header and 2 items", len(rows))
+	}
+
+	remoteDir := "/image/GoodsColor/" + time.Now().Format("20060102") + "/"
+	want := [][]string{
+		{"Images", "Color Pic", "Size Table", "Default Image FTP Path", "API Result"},
+		{"2", "found", "found", remoteDir + "ITEM1_01.jpg", "success"},
+		{"1", "", "missing", "", "not_found"},
+	}
+	for i, row := range rows {
+		var got []string
+		if len(row) > 12 {
+			got = row[12:]
+		}
+		if strings.Join(got, "|") != strings.Join(want[i], "|") {
+			t.Errorf("row %d status = %q, want %q", i+1, got, want[i])
+		}
+	}
+	if rows[1][3] != "ITEM1" {
+		t.Errorf("row 2 item = %q, want the original data below the header", rows[1][3])
+	}
+}
+
+func TestUploadVerifyReuploadsCorruptFile(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.VerifyUpload = true
+	ftpSrv, apiSrv := startServers(t, &cfg, mockapi.Script{})
+
+	// Truncate the first upload of ITEM1_02.jpg only
+	corrupted := false
+	ftpSrv.Corrupt = func(name string, data []byte) []byte {
+		if strings.HasSuffix(name, "/ITEM1_02.jpg") && !corrupted {
+			corrupted = true
+			return data[:len(data)/2]
+		}
+		return data
+	}
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+
+	if !corrupted {
+		t.Fatal("corruption hook never ran")
+	}
+	local, _ := os.ReadFile(filepath.Join(cfg.WorkPath, "Brand_Season", "ITEM1_Red", "SMALL", "ITEM1_02.jpg"))
+	remote := ftpSrv.Files()["/GoodsColor/"+time.Now().Format("20060102")+"/ITEM1_02.jpg"]
+	if !bytes.Equal(local, remote) {
+		t.Error("corrupted file was not re-uploaded")
+	}
+	if requests := apiSrv.Requests(); len(requests) != 1 || len(requests[0].Payload) != 6 {
+		t.Errorf("API should receive all 6 images once, got %d requests", len(requests))
+	}
+}
+
+func TestUploadVerifyRemovesBrokenFile(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.VerifyUpload = true
+	cfg.VerifyRetries = 0
+	ftpSrv, apiSrv := startServers(t, &cfg, mockapi.Script{})
+
+	uploads := 0
+	ftpSrv.Corrupt = func(name string, data []byte) []byte {
+		if strings.HasSuffix(name, "/ITEM1_02.jpg") {
+			uploads++
+			return data[:len(data)/2]
+		}
+		return data
+	}
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	if err := RunUpload(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunUpload: %v", err)
+	}
+
+	if uploads != 1 {
+		t.Errorf("ITEM1_02.jpg uploaded %d times, want 1 with VerifyRetries 0", uploads)
+	}
+	for _, name := range ftpSrv.Names() {
+		if strings.HasSuffix(name, "/ITEM1_02.jpg") {
+			t.Errorf("broken %s left on FTP", name)
+		}
+	}
+	requests := apiSrv.Requests()
+	if len(requests) != 1 || len(requests[0].Payload) != 5 {
+		t.Fatalf("API should receive the 5 verified images once, got %d requests", len(requests))
+	}
+	if _, ok := requests[0].Payload["ITEM1_02.jpg"]; ok {
+		t.Error("broken ITEM1_02.jpg sent to the API")
+	}
+	if m := readManifest(t, cfg.WorkPath)[smallKey("Brand_Season", "ITEM1_Red", "ITEM1_02.jpg")]; m.FtpPath != "" {
+		t.Errorf("manifest keeps ftp_path %s of the removed file", m.FtpPath)
+	}
+}
@@ -0,0 +1,118 @@
+package logic
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultRemotePathTemplate keeps the original flat daily layout on the FTP server.
+const DefaultRemotePathTemplate = "GoodsColor/{date}/{filename}"
+
+// remotePathVars are the values available to the remote path template.
+type remotePathVars struct {
+	Date      time.Time
+	Item      string // Excel column D
+	Folder    string // Level 1 folder, columns A_B
+	Color     string // Excel column G
+	Rendition string // SMALL, BIG, ...
+	Filename  string
+}
+
+var templateVarPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// remotePathTemplateVars lists the placeholders understood by renderRemotePath.
+var remotePathTemplateVars = []string{"date", "year", "month", "day", "time", "item", "folder", "color", "rendition", "filename"}
+
+// validateRemotePathTemplate rejects unknown placeholders and templates that
+// would put several files on the same remote path.
+func validateRemotePathTemplate(tmpl string) error {
+	for _, m := range templateVarPattern.FindAllStringSubmatch(tmpl, -1) {
+		known := false
+		for _, name := range remotePathTemplateVars {
+			if m[1] == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder %s in remote path template (allowed: %s)", m[0], strings.Join(remotePathTemplateVars, ", "))
+		}
+	}
+	if !strings.Contains(tmpl, "{filename}") {
+		return fmt.Errorf("remote path template %q must contain {filename}", tmpl)
+	}
+	for _, part := range strings.Split(strings.ReplaceAll(tmpl, "\\", "/"), "/") {
+		if part == ".." {
+			return fmt.Errorf("remote path template %q must not contain ..", tmpl)
+		}
+	}
+	return nil
+}
+
+// renderRemotePath fills the template and returns a clean slash separated path
+// relative to the FTP root.
+func renderRemotePath(tmpl string, v remotePathVars) string {
+	if tmpl == "" {
+		tmpl = DefaultRemotePathTemplate
+	}
+	values := map[string]string{
+		"date":      v.Date.Format("20060102"),
+		"year":      v.Date.Format("2006"),
+		"month":     v.Date.Format("01"),
+		"day":       v.Date.Format("02"),
+		"time":      v.Date.Format("150405"),
+		"item":      v.Item,
+		"folder":    v.Folder,
+		"color":     v.Color,
+		"rendition": v.Rendition,
+		"filename":  v.Filename,
+	}
+	out := templateVarPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		// A value must never introduce extra directory levels or step out of one
+		value := strings.NewReplacer("/", "", "\\", "").Replace(values[m[1:len(m)-1]])
+		if strings.Trim(value, ".") == "" {
+			return ""
+		}
+		return value
+	})
+	return strings.TrimPrefix(path.Clean("/"+out), "/")
+}
+
+// localPathVars derives the template values for a file inside the split layout
+// WorkPath/<folder>/<item>_<color>/<rendition>/<filename>. Values recorded in
+// the manifest win over values guessed from the directory names.
+func localPathVars(workPath, localPath string, meta ImageMetadata, date time.Time) remotePathVars {
+	v := remotePathVars{
+		Date:     date,
+		Filename: filepath.Base(localPath),
+		Item:     meta.ExcelColD,
+		Folder:   meta.Folder,
+		Color:    meta.Color,
+	}
+
+	if rel, err := filepath.Rel(workPath, localPath); err == nil {
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) >= 4 {
+			if v.Folder == "" {
+				v.Folder = parts[0]
+			}
+			v.Rendition = parts[len(parts)-2]
+			itemDir := parts[len(parts)-3]
+			if v.Item == "" {
+				// Untracked files, and color pics of older manifests, carry the item code in their name
+				base := strings.TrimSuffix(v.Filename, filepath.Ext(v.Filename))
+				if i := strings.LastIndex(base, "_"); i > 0 {
+					v.Item = base[:i]
+				}
+			}
+			if v.Color == "" && v.Item != "" && strings.HasPrefix(itemDir, v.Item+"_") {
+				v.Color = strings.TrimPrefix(itemDir, v.Item+"_")
+			}
+		}
+	}
+	return v
+}
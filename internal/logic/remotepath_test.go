@@ -0,0 +1,102 @@
+package logic
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderRemotePath(t *testing.T) {
+	vars := remotePathVars{
+		Date:      time.Date(2026, 3, 4, 15, 6, 7, 0, time.Local),
+		Item:      "ITEM1",
+		Folder:    "Brand_Season",
+		Color:     "Red",
+		Rendition: "SMALL",
+		Filename:  "ITEM1_01.jpg",
+	}
+	for _, tc := range []struct {
+		tmpl string
+		want string
+	}{
+		{"", "GoodsColor/20260304/ITEM1_01.jpg"},
+		{"{year}/{month}/{day}/{time}/{filename}", "2026/03/04/150607/ITEM1_01.jpg"},
+		{"{folder}/{item}_{color}/{rendition}/{filename}", "Brand_Season/ITEM1_Red/SMALL/ITEM1_01.jpg"},
+		{"/GoodsColor//{date}/./{filename}", "GoodsColor/20260304/ITEM1_01.jpg"},
+		{"../../{filename}", "ITEM1_01.jpg"}, // Rejected by validateRemotePathTemplate, still kept inside the root
+	} {
+		if got := renderRemotePath(tc.tmpl, vars); got != tc.want {
+			t.Errorf("renderRemotePath(%q) = %q, want %q", tc.tmpl, got, tc.want)
+		}
+	}
+
+	// Values cannot add or leave directory levels
+	for _, tc := range []struct {
+		item, color string
+		want        string
+	}{
+		{"../etc", "Red", "GoodsColor/..etc/Red/ITEM1_01.jpg"},
+		{"..", "Red", "GoodsColor/Red/ITEM1_01.jpg"},
+		{"A/B", `C\D`, "GoodsColor/AB/CD/ITEM1_01.jpg"},
+		{"/ITEM1", ".", "GoodsColor/ITEM1/ITEM1_01.jpg"},
+	} {
+		v := vars
+		v.Item, v.Color = tc.item, tc.color
+		if got := renderRemotePath("GoodsColor/{item}/{color}/{filename}", v); got != tc.want {
+			t.Errorf("item %q, color %q: %q, want %q", tc.item, tc.color, got, tc.want)
+		}
+	}
+}
+
+func TestValidateRemotePathTemplate(t *testing.T) {
+	for tmpl, want := range map[string]string{
+		"GoodsColor/{date}/{filename}":    "",
+		"{folder}/{rendition}/{filename}": "",
+		"GoodsColor/{size}/{filename}":    "unknown placeholder {size}",
+		"GoodsColor/{date}/{item}.jpg":    "must contain {filename}",
+		"GoodsColor/../{filename}":        "must not contain ..",
+		`GoodsColor\..\{date}\{filename}`: "must not contain ..",
+		"GoodsColor/{date}..{filename}":   "",
+	} {
+		err := validateRemotePathTemplate(tmpl)
+		if want == "" && err != nil || want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Errorf("validateRemotePathTemplate(%q) = %v, want %q", tmpl, err, want)
+		}
+	}
+}
+
+func TestLocalPathVars(t *testing.T) {
+	workPath := "work"
+	date := time.Now()
+	for _, tc := range []struct {
+		rel  string
+		meta ImageMetadata
+		want remotePathVars
+	}{
+		{
+			"Brand_Season/ITEM1_Red/SMALL/ITEM1_01.jpg", ImageMetadata{ExcelColD: "ITEM1"},
+			remotePathVars{Item: "ITEM1", Folder: "Brand_Season", Color: "Red", Rendition: "SMALL", Filename: "ITEM1_01.jpg"},
+		},
+		{
+			// Manifest values win over the directory names
+			"Brand_Season/ITEM1_Green/SMALL/ITEM1_Green_01.jpg", ImageMetadata{ExcelColD: "ITEM1", Folder: "Other", Color: "Dark Green"},
+			remotePathVars{Item: "ITEM1", Folder: "Other", Color: "Dark Green", Rendition: "SMALL", Filename: "ITEM1_Green_01.jpg"},
+		},
+		{
+			// Untracked file, the item code comes from its name
+			"Brand_Season/ITEM2_Blue/BIG/ITEM2_Color.png", ImageMetadata{},
+			remotePathVars{Item: "ITEM2", Folder: "Brand_Season", Color: "Blue", Rendition: "BIG", Filename: "ITEM2_Color.png"},
+		},
+		{
+			// Outside the split layout only the file name is known
+			"loose.jpg", ImageMetadata{},
+			remotePathVars{Filename: "loose.jpg"},
+		},
+	} {
+		tc.want.Date = date
+		if got := localPathVars(workPath, filepath.Join(workPath, filepath.FromSlash(tc.rel)), tc.meta, date); got != tc.want {
+			t.Errorf("localPathVars(%s) = %+v, want %+v", tc.rel, got, tc.want)
+		}
+	}
+}
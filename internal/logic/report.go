@@ -0,0 +1,300 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"ahMakerdir/internal/api"
+	"ahMakerdir/internal/manifest"
+)
+
+// File statuses in the run report
+const (
+	fileUploaded     = "uploaded"
+	fileReused       = "reused"        // Identical file already on FTP
+	fileSkipped      = "skipped"       // Collision policy skip
+	fileFailed       = "failed"        // Upload error
+	fileVerifyFailed = "verify_failed" // Remote copy did not match and was removed
+	fileRolledBack   = "rolled_back"   // Removed after the API call failed
+	fileRemoved      = "removed"       // Removed because the SN was not found
+)
+
+// RunReport ties every Excel row of an upload run to its files, remote paths
+// and API outcome. It is saved to ApiResults as JSON and HTML.
+type RunReport struct {
+	RunID                   string        `json:"run_id"`
+	StartedAt               time.Time     `json:"started_at"`
+	FinishedAt              time.Time     `json:"finished_at"`
+	WorkPath                string        `json:"work_path"`
+	Items                   []*ReportItem `json:"items"`
+	SuccessGoodsColorPicIDs []int         `json:"success_goods_color_pic_ids,omitempty"`
+	SuccessGoodsColorIDs    []int         `json:"success_goods_color_ids,omitempty"`
+	Warnings                []string      `json:"warnings,omitempty"`
+
+	items     map[string]*ReportItem // by item folder, one per Excel row
+	files     map[string]*ReportFile // by local path
+	fileItems map[string]*ReportItem // by local path
+}
+
+// ReportItem is one Excel row. Rows sharing an item code are separate items.
+type ReportItem struct {
+	ItemCode  string        `json:"item_code"`
+	Sheet     string        `json:"sheet,omitempty"`
+	ExcelRow  int           `json:"excel_row,omitempty"`
+	Folder    string        `json:"folder,omitempty"`
+	Color     string        `json:"color,omitempty"`
+	ApiResult string        `json:"api_result"` // success, not_found, failed, not_sent
+	ApiError  string        `json:"api_error,omitempty"`
+	Files     []*ReportFile `json:"files"`
+	Warnings  []string      `json:"warnings,omitempty"`
+}
+
+// ReportFile is one file of an item.
+type ReportFile struct {
+	Filename    string `json:"filename"`
+	Type        string `json:"type,omitempty"` // image or color
+	SourceImage string `json:"source_image,omitempty"`
+	Size        int64  `json:"size"`
+	RemotePath  string `json:"remote_path,omitempty"`
+	Sort        int    `json:"sort,omitempty"`
+	IsDef       int    `json:"is_def"`
+	Status      string `json:"status"`
+}
+
+func newRunReport(workPath string) *RunReport {
+	now := time.Now()
+	return &RunReport{
+		RunID:     now.Format("20060102_150405"),
+		StartedAt: now,
+		WorkPath:  workPath,
+		items:     make(map[string]*ReportItem),
+		files:     make(map[string]*ReportFile),
+		fileItems: make(map[string]*ReportItem),
+	}
+}
+
+// item returns the item of the files in dir, the <code>_<color> folder Split
+// made for the row.
+func (r *RunReport) item(dir, code string) *ReportItem {
+	it, ok := r.items[dir]
+	if !ok {
+		it = &ReportItem{ItemCode: code, ApiResult: "not_sent"}
+		r.items[dir] = it
+		r.Items = append(r.Items, it)
+	}
+	return it
+}
+
+// rowItem returns the item of an Excel row, or nil. Items of manifests from
+// before excel_row was recorded are found by their code.
+func (r *RunReport) rowItem(sheet string, excelRow int, code string) *ReportItem {
+	for _, it := range r.Items {
+		if it.Sheet == sheet && it.ExcelRow == excelRow {
+			return it
+		}
+	}
+	for _, it := range r.Items {
+		if it.ExcelRow == 0 && it.ItemCode == code {
+			return it
+		}
+	}
+	return nil
+}
+
+// addFile records a file seen by the upload. vars carries the item values
+// for files that are not in the manifest, such as color pics.
+func (r *RunReport) addFile(localPath string, meta ImageMetadata, vars remotePathVars, remotePath, status string) {
+	// Files are in <folder>/<code>_<color>/SMALL
+	it := r.item(filepath.Dir(filepath.Dir(localPath)), vars.Item)
+	if meta.ExcelRow > 0 {
+		it.Sheet = meta.Sheet
+		it.ExcelRow = meta.ExcelRow
+	}
+	if it.Folder == "" {
+		it.Folder = vars.Folder
+	}
+	if it.Color == "" {
+		it.Color = vars.Color
+	}
+	for _, w := range meta.Warnings {
+		it.warn(w)
+	}
+
+	f := &ReportFile{
+		Filename:    filepath.Base(localPath),
+		Type:        meta.Type,
+		SourceImage: meta.SourceImage,
+		Sort:        meta.Sort,
+		IsDef:       meta.IsDef,
+		Status:      status,
+	}
+	if remotePath != "" {
+		f.RemotePath = "/image/" + remotePath
+	}
+	if info, err := os.Stat(localPath); err == nil {
+		f.Size = info.Size()
+	}
+	it.Files = append(it.Files, f)
+	r.files[localPath] = f
+	r.fileItems[localPath] = it
+}
+
+// setStatus changes the status of a recorded file.
+func (r *RunReport) setStatus(localPath, status string) {
+	if f, ok := r.files[localPath]; ok {
+		f.Status = status
+	}
+}
+
+// applyToManifest stores the upload state of every recorded file in m. Only
+// files that are on the server keep their FTP path.
+func (r *RunReport) applyToManifest(m *manifest.Manifest) {
+	for localPath, rf := range r.files {
+		_, f, ok := m.Lookup(entryKey(r.WorkPath, localPath))
+		if !ok {
+			continue
+		}
+		u := &manifest.Upload{Status: rf.Status, UploadedAt: r.StartedAt}
+		switch rf.Status {
+		case fileUploaded, fileReused:
+			u.FtpPath = rf.RemotePath
+		}
+		f.Upload = u
+	}
+}
+
+// setStatusByRemote changes the status of the files stored at the given remote paths.
+func (r *RunReport) setStatusByRemote(remotePaths map[string]bool, status string) {
+	for _, f := range r.files {
+		if f.RemotePath != "" && remotePaths[f.RemotePath[len("/image/"):]] {
+			f.Status = status
+		}
+	}
+}
+
+func (it *ReportItem) warn(msg string) {
+	for _, w := range it.Warnings {
+		if w == msg {
+			return
+		}
+	}
+	it.Warnings = append(it.Warnings, msg)
+}
+
+// applyAPI fills in the API result of every item sent in payload; locals
+// gives the local path of each payload key.
+func (r *RunReport) applyAPI(payload api.Payload, locals map[string]string, outcome *apiOutcome) {
+	for key, p := range payload {
+		it, ok := r.fileItems[locals[key]]
+		if !ok {
+			continue
+		}
+		switch {
+		case outcome.NotFound[p.ExcelColD]:
+			it.ApiResult = "not_found"
+		case outcome.Failed[key] != "":
+			it.ApiResult = "failed"
+			it.ApiError = outcome.Failed[key]
+		case outcome.Accepted[key] && it.ApiResult != "failed":
+			it.ApiResult = "success"
+		}
+	}
+	for _, it := range r.Items {
+		if it.ApiResult == "not_found" {
+			for _, f := range it.Files {
+				if f.Status == fileUploaded || f.Status == fileReused {
+					f.Status = fileRemoved
+				}
+			}
+		}
+	}
+	if outcome.Response != nil {
+		r.SuccessGoodsColorPicIDs = outcome.Response.SuccessGoodsColorPicIDs
+		r.SuccessGoodsColorIDs = outcome.Response.SuccessGoodsColorIDs
+		for _, e := range outcome.Response.Errors {
+			// The API knows item codes only, every row of the code gets the message
+			found := false
+			for _, it := range r.Items {
+				if e.SN != "" && it.ItemCode == e.SN {
+					it.warn("API: " + e.Message)
+					found = true
+				}
+			}
+			if !found {
+				r.Warnings = append(r.Warnings, "API: "+e.String())
+			}
+		}
+	}
+}
+
+// Save writes run_report_<run id>.json and .html to dir and returns the HTML path.
+func (r *RunReport) Save(dir string) (string, error) {
+	r.FinishedAt = time.Now()
+	sort.Slice(r.Items, func(i, j int) bool {
+		if r.Items[i].Sheet != r.Items[j].Sheet {
+			return r.Items[i].Sheet < r.Items[j].Sheet
+		}
+		if r.Items[i].ExcelRow != r.Items[j].ExcelRow {
+			return r.Items[i].ExcelRow < r.Items[j].ExcelRow
+		}
+		return r.Items[i].ItemCode < r.Items[j].ItemCode
+	})
+	for _, it := range r.Items {
+		sort.Slice(it.Files, func(i, j int) bool { return it.Files[i].Filename < it.Files[j].Filename })
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	base := filepath.Join(dir, "run_report_"+r.RunID)
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".json", data, 0644); err != nil {
+		return "", err
+	}
+
+	f, err := os.Create(base + ".html")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := reportTemplate.Execute(f, r); err != nil {
+		return "", fmt.Errorf("failed to render report: %v", err)
+	}
+	return base + ".html", nil
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"kb": func(n int64) string { return fmt.Sprintf("%.1f KB", float64(n)/1024) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Upload report {{.RunID}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+.success { color: #070; } .not_found, .failed, .verify_failed { color: #b00; } .not_sent { color: #888; }
+.warn { color: #a60; }
+</style></head><body>
+<h1>Upload report {{.RunID}}</h1>
+<p>Work path: {{.WorkPath}}<br>Started: {{.StartedAt.Format "2006-01-02 15:04:05"}}, finished: {{.FinishedAt.Format "2006-01-02 15:04:05"}}<br>
+goods_color_pic IDs: {{len .SuccessGoodsColorPicIDs}}, goods_color IDs: {{len .SuccessGoodsColorIDs}}</p>
+{{range .Warnings}}<p class="warn">{{.}}</p>{{end}}
+<table>
+<tr><th>Row</th><th>Item</th><th>Folder</th><th>Color</th><th>API</th><th>Files</th><th>Warnings</th></tr>
+{{range .Items}}<tr>
+<td>{{if .Sheet}}{{.Sheet}}!{{end}}{{if .ExcelRow}}{{.ExcelRow}}{{end}}</td><td>{{.ItemCode}}</td><td>{{.Folder}}</td><td>{{.Color}}</td>
+<td class="{{.ApiResult}}">{{.ApiResult}}{{if .ApiError}}<br>{{.ApiError}}{{end}}</td>
+<td><table>{{range .Files}}<tr><td>{{.Filename}}</td><td>{{.SourceImage}}</td><td>{{kb .Size}}</td><td>{{if eq .Type "color"}}color pic{{else}}sort {{.Sort}} / is_def {{.IsDef}}{{end}}</td><td>{{.RemotePath}}</td><td class="{{.Status}}">{{.Status}}</td></tr>{{end}}</table></td>
+<td class="warn">{{range .Warnings}}{{.}}<br>{{end}}</td>
+</tr>{{end}}
+</table>
+</body></html>
+`))
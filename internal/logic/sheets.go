@@ -0,0 +1,108 @@
+package logic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"ahMakerdir/internal/config"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// sheetInput is one sheet RunSplit processes.
+type sheetInput struct {
+	Name       string // Sheet name in the workbook
+	Label      string // Sheet name recorded in messages and the manifest, empty when only one sheet is processed
+	PictureDir string // Directory under the work path holding the sheet's pictures
+	Rows       [][]string
+}
+
+type sheetSpec struct {
+	Sheet          string // Name or 1-based index, empty for the active sheet
+	PictureDirName string
+}
+
+// parseSheets reads the Sheets setting: sheet names or 1-based indexes separated
+// by commas, each optionally followed by =<picture dir>. Sheets without their
+// own directory use PictureDirName. Empty selects the active sheet.
+func parseSheets(cfg config.Config) []sheetSpec {
+	var specs []sheetSpec
+	for _, part := range strings.Split(cfg.Sheets, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		spec := sheetSpec{Sheet: part, PictureDirName: cfg.PictureDirName}
+		if name, dir, ok := strings.Cut(part, "="); ok {
+			spec.Sheet = strings.TrimSpace(name)
+			if dir = strings.TrimSpace(dir); dir != "" {
+				spec.PictureDirName = dir
+			}
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		specs = append(specs, sheetSpec{PictureDirName: cfg.PictureDirName})
+	}
+	return specs
+}
+
+// resolveSheet returns the name of the sheet selected by name or 1-based index.
+func resolveSheet(xlsx *excelize.File, sheet string) (string, error) {
+	if sheet == "" {
+		return xlsx.GetSheetName(xlsx.GetActiveSheetIndex()), nil
+	}
+	list := xlsx.GetSheetList()
+	for _, name := range list {
+		if name == sheet {
+			return name, nil
+		}
+	}
+	if n, err := strconv.Atoi(sheet); err == nil {
+		if n < 1 || n > len(list) {
+			return "", fmt.Errorf("sheet %d out of range, the workbook has %d sheets", n, len(list))
+		}
+		return list[n-1], nil
+	}
+	for _, name := range list {
+		if strings.EqualFold(name, sheet) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("sheet %q not found, the workbook has: %s", sheet, strings.Join(list, ", "))
+}
+
+// loadSheets reads the sheets selected in the config from the workbook in the work path.
+func loadSheets(cfg config.Config) (string, []sheetInput, error) {
+	excelFile, xlsx, err := openWorkbook(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	defer xlsx.Close()
+
+	specs := parseSheets(cfg)
+	var inputs []sheetInput
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		name, err := resolveSheet(xlsx, spec.Sheet)
+		if err != nil {
+			return "", nil, err
+		}
+		if seen[name] {
+			return "", nil, fmt.Errorf("sheet %q is selected more than once", name)
+		}
+		seen[name] = true
+
+		rows, err := xlsx.GetRows(name)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to get rows of sheet %q: %w", name, err)
+		}
+		in := sheetInput{Name: name, PictureDir: spec.PictureDirName, Rows: rows}
+		if len(specs) > 1 {
+			in.Label = name
+		}
+		inputs = append(inputs, in)
+	}
+	return excelFile, inputs, nil
+}
@@ -0,0 +1,110 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// addSheet adds a sheet with rows to the test workbook and a picture directory with n images.
+func addSheet(t *testing.T, workPath, sheet, picDir string, n int, rows [][]string) {
+	t.Helper()
+	xlsx, err := excelize.OpenFile(filepath.Join(workPath, "list.xlsx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xlsx.Close()
+	if _, err := xlsx.NewSheet(sheet); err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		values := make([]interface{}, len(row))
+		for j, v := range row {
+			values[j] = v
+		}
+		xlsx.SetSheetRow(sheet, cell, &values)
+	}
+	if err := xlsx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(workPath, picDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		writeTestJPEG(t, filepath.Join(workPath, picDir, "b ("+string(rune('0'+i))+").jpg"), 30, 40, nil)
+	}
+}
+
+func TestSplitMultipleSheets(t *testing.T) {
+	cfg := newWorkPath(t)
+	addSheet(t, cfg.WorkPath, "Kids", "org_kids", 1, [][]string{
+		{"Kids", "Season", "S100-1", "ITEM3", "", "", "Green", "", "1", "1"},
+	})
+	cfg.Sheets = "Sheet1, kids=org_kids"
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	entries := readManifest(t, cfg.WorkPath)
+	if m := entries[smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01.jpg")]; m.Sheet != "Sheet1" || m.ExcelRow != 1 {
+		t.Errorf("manifest[ITEM1_01.jpg] = %+v", m)
+	}
+	if m := entries[smallKey("Kids_Season", "ITEM3_Green", "ITEM3_01.jpg")]; m.Sheet != "Kids" || m.ExcelRow != 1 || m.SourceImage != "b (1).jpg" {
+		t.Errorf("manifest[ITEM3_01.jpg] = %+v", m)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.WorkPath, "Kids_Season", "ITEM3_Green", "SMALL", "ITEM3_01.jpg")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSheetSelection(t *testing.T) {
+	cfg := newWorkPath(t)
+	addSheet(t, cfg.WorkPath, "Kids", "org_kids", 1, [][]string{
+		{"Kids", "Season", "S100-1", "ITEM1", "", "", "Green", "", "1"},
+	})
+
+	for _, tc := range []struct {
+		sheets string
+		want   string // Sheet name or error text
+	}{
+		{"", "Sheet1"},
+		{"2", "Kids"},
+		{"KIDS", "Kids"},
+		{"3", "out of range"},
+		{"Adults", "not found"},
+		{"Kids, 2", "more than once"},
+	} {
+		cfg.Sheets = tc.sheets
+		_, sheets, err := loadSheets(cfg)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = sheets[0].Name
+		}
+		if !strings.Contains(got, tc.want) {
+			t.Errorf("Sheets %q: got %q, want %q", tc.sheets, got, tc.want)
+		}
+	}
+
+	// Item codes must be unique across the selected sheets
+	cfg.Sheets = "1,2=org_kids"
+	issues, err := ValidateExcel(cfg, testLog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, issue := range issues {
+		if issue.Sheet == "Kids" && issue.Column == "D" && strings.Contains(issue.Message, "Sheet1!D1") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no duplicate item code error across sheets in %v", issues)
+	}
+}
@@ -0,0 +1,302 @@
+package logic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"ahMakerdir/internal/config"
+
+	"github.com/disintegration/imaging"
+)
+
+// Size table lookup rules for SizeTableRules, tried in order
+const (
+	SizeTableRuleStyle  = "style"  // Column C up to the first "-" (original behavior)
+	SizeTableRuleCode   = "code"   // Column C as written
+	SizeTableRulePrefix = "prefix" // prefix:N, the first N characters of the style
+	SizeTableRuleSheet  = "sheet"  // sheet:<name>, column A style or code -> column B file name
+)
+
+type sizeTableRule struct {
+	kind  string
+	n     int
+	sheet string
+}
+
+func (r sizeTableRule) String() string {
+	switch r.kind {
+	case SizeTableRulePrefix:
+		return fmt.Sprintf("%s:%d", r.kind, r.n)
+	case SizeTableRuleSheet:
+		return r.kind + ":" + r.sheet
+	}
+	return r.kind
+}
+
+// sizeTableMatch is the size table found for a row and the rule that found it.
+type sizeTableMatch struct {
+	Path     string
+	Rule     string
+	Generate bool // No image found, rendered from SizeTableData
+}
+
+// sizeTableResolver finds the size table of a row in SizeTablePath.
+type sizeTableResolver struct {
+	dir     string
+	rules   []sizeTableRule
+	exts    []string
+	mapping map[string]map[string]string // sheet -> normalized style or code -> file name
+	files   *fileLookup                  // Listing of dir, made on first use
+	index   *sourceIndex                 // Shared listings and mirror of the run, may be nil
+	gen     *sizeTableGenerator          // Fallback when SizeTableGenerate is on
+}
+
+// newSizeTableResolver parses SizeTableRules and SizeTableExtensions and loads
+// the mapping sheets they refer to. index may be nil.
+func newSizeTableResolver(cfg config.Config, index *sourceIndex) (*sizeTableResolver, error) {
+	r := &sizeTableResolver{
+		dir:     strings.TrimSpace(cfg.SizeTablePath),
+		mapping: make(map[string]map[string]string),
+		index:   index,
+	}
+
+	for _, ext := range strings.Split(cfg.SizeTableExtensions, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		r.exts = append(r.exts, ext)
+	}
+	if len(r.exts) == 0 {
+		r.exts = []string{".jpg"}
+	}
+
+	for _, part := range strings.Split(cfg.SizeTableRules, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, arg, _ := strings.Cut(part, ":")
+		rule := sizeTableRule{kind: strings.ToLower(strings.TrimSpace(kind))}
+		arg = strings.TrimSpace(arg)
+		switch rule.kind {
+		case SizeTableRuleStyle, SizeTableRuleCode:
+		case SizeTableRulePrefix:
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("size table rule %q needs a length, e.g. prefix:5", part)
+			}
+			rule.n = n
+		case SizeTableRuleSheet:
+			if arg == "" {
+				return nil, fmt.Errorf("size table rule %q needs a sheet name, e.g. sheet:SizeTables", part)
+			}
+			rule.sheet = arg
+		default:
+			return nil, fmt.Errorf("unknown size table rule %q", part)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	if len(r.rules) == 0 {
+		r.rules = []sizeTableRule{{kind: SizeTableRuleStyle}}
+	}
+
+	for _, rule := range r.rules {
+		if rule.kind == SizeTableRuleSheet {
+			if err := r.loadMapping(cfg, rule.sheet); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.SizeTableGenerate {
+		gen, err := newSizeTableGenerator(cfg)
+		if err != nil {
+			return nil, err
+		}
+		r.gen = gen
+	}
+	return r, nil
+}
+
+func (r *sizeTableResolver) loadMapping(cfg config.Config, sheet string) error {
+	if _, ok := r.mapping[sheet]; ok {
+		return nil
+	}
+	_, xlsx, err := openWorkbook(cfg)
+	if err != nil {
+		return err
+	}
+	defer xlsx.Close()
+
+	name, err := resolveSheet(xlsx, sheet)
+	if err != nil {
+		return fmt.Errorf("size table mapping: %w", err)
+	}
+	rows, err := xlsx.GetRows(name)
+	if err != nil {
+		return fmt.Errorf("size table mapping: %w", err)
+	}
+	m := make(map[string]string)
+	for _, row := range rows {
+		if len(row) >= 2 && strings.TrimSpace(row[0]) != "" && strings.TrimSpace(row[1]) != "" {
+			m[normalizeName(row[0])] = strings.TrimSpace(row[1])
+		}
+	}
+	r.mapping[sheet] = m
+	return nil
+}
+
+// styleNo is the style number RunSplit names the size table copy after.
+func styleNo(row []string) string {
+	return strings.Split(row[2], "-")[0]
+}
+
+// ruleNames returns the rules in the order they are tried.
+func (r *sizeTableResolver) ruleNames() string {
+	var names []string
+	for _, rule := range r.rules {
+		names = append(names, rule.String())
+	}
+	if r.gen != nil {
+		names = append(names, "generate")
+	}
+	return strings.Join(names, ", ")
+}
+
+// resolve returns the first size table found for the row.
+func (r *sizeTableResolver) resolve(row []string) (sizeTableMatch, bool) {
+	style := strings.TrimSpace(styleNo(row))
+	code := strings.TrimSpace(row[2])
+	for _, rule := range r.rules {
+		var path string
+		switch rule.kind {
+		case SizeTableRuleStyle:
+			path = r.find(style)
+		case SizeTableRuleCode:
+			path = r.find(code)
+		case SizeTableRulePrefix:
+			if prefix := []rune(style); len(prefix) > rule.n {
+				path = r.find(string(prefix[:rule.n]))
+			}
+		case SizeTableRuleSheet:
+			name, ok := r.mapping[rule.sheet][normalizeName(code)]
+			if !ok {
+				name, ok = r.mapping[rule.sheet][normalizeName(style)]
+			}
+			if ok {
+				if filepath.Ext(name) != "" {
+					path = r.exists(name)
+				} else {
+					path = r.find(name)
+				}
+			}
+		}
+		if path != "" {
+			return sizeTableMatch{Path: path, Rule: rule.String()}, true
+		}
+	}
+	if r.gen != nil && r.gen.has(row) {
+		return sizeTableMatch{Rule: "generate", Generate: true}, true
+	}
+	return sizeTableMatch{}, false
+}
+
+// write stores the size table of a match at dst, rendering generated ones.
+func (r *sizeTableResolver) write(match sizeTableMatch, row []string, dst string) error {
+	if match.Generate {
+		return r.gen.generate(row, dst)
+	}
+	// JPEGs are copied as they are, from the source straight to dst
+	if ext := strings.ToLower(filepath.Ext(match.Path)); ext == ".jpg" || ext == ".jpeg" {
+		return r.index.copyFile(match.Path, dst)
+	}
+	return copySizeTable(r.index.local(match.Path), dst)
+}
+
+// find tries name with each extension.
+func (r *sizeTableResolver) find(name string) string {
+	if name == "" {
+		return ""
+	}
+	for _, ext := range r.exts {
+		if path := r.exists(name + ext); path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+func (r *sizeTableResolver) exists(name string) string {
+	return r.lookup().get(name)
+}
+
+func (r *sizeTableResolver) lookup() *fileLookup {
+	if r.files == nil {
+		r.files = r.index.lookup(r.dir)
+	}
+	return r.files
+}
+
+// suggestion lists the size tables closest to the style of a row that has none.
+func (r *sizeTableResolver) suggestion(row []string) string {
+	return r.lookup().suggestion(strings.TrimSpace(styleNo(row)))
+}
+
+// copySizeTable copies a size table to dst, converting other formats to JPEG
+// since the copy is always named .jpg.
+func copySizeTable(src, dst string) error {
+	ext := strings.ToLower(filepath.Ext(src))
+	if ext == ".jpg" || ext == ".jpeg" {
+		return copyFile(src, dst)
+	}
+	img, err := imaging.Open(src)
+	if err != nil {
+		return err
+	}
+	// Transparent areas would turn black in a JPEG
+	bg := imaging.New(img.Bounds().Dx(), img.Bounds().Dy(), color.White)
+	flat := imaging.Overlay(bg, img, image.Pt(0, 0), 1.0)
+	return imaging.Save(flat, dst, imaging.JPEGQuality(95))
+}
+
+// logSizeTables lists the size table and matching rule of every row, missing
+// ones first, before anything is copied.
+func logSizeTables(resolver *sizeTableResolver, sheets []sheetInput, log func(string)) {
+	var found, missing []string
+	for _, sheet := range sheets {
+		for index, row := range sheet.Rows {
+			if !isDataRow(row) {
+				continue
+			}
+			cell := ValidationIssue{Sheet: sheet.Label, Row: index + 1, Column: "C"}.cell()
+			if match, ok := resolver.resolve(row); ok && match.Generate {
+				found = append(found, fmt.Sprintf("  %s %s: generated from size table data", cell, row[3]))
+			} else if ok {
+				found = append(found, fmt.Sprintf("  %s %s: %s (%s)", cell, row[3], filepath.Base(match.Path), match.Rule))
+			} else {
+				missing = append(missing, fmt.Sprintf("  %s %s: %s%s", cell, row[3], row[2], resolver.suggestion(row)))
+			}
+		}
+	}
+	log(fmt.Sprintf("Size table rules: %s", resolver.ruleNames()))
+	if len(missing) > 0 {
+		log(fmt.Sprintf("Size tables missing for %d rows:", len(missing)))
+		for _, line := range missing {
+			log(line)
+		}
+	}
+	if len(found) > 0 {
+		log(fmt.Sprintf("Size tables found for %d rows:", len(found)))
+		for _, line := range found {
+			log(line)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSizeTableRules(t *testing.T) {
+	cfg := newWorkPath(t)
+	addSheet(t, cfg.WorkPath, "SizeTables", "org", 0, [][]string{
+		{"Style", "Size table"},
+		{"K900-1", "kids_generic"},
+	})
+	writeTestJPEG(t, filepath.Join(cfg.SizeTablePath, "S3001.jpg"), 10, 10, nil)
+	writeTestJPEG(t, filepath.Join(cfg.SizeTablePath, "kids_generic.jpg"), 10, 10, nil)
+	f, err := os.Create(filepath.Join(cfg.SizeTablePath, "P7.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 12, 8)))
+	f.Close()
+
+	cfg.SizeTableRules = "style, prefix:5, sheet:SizeTables"
+	cfg.SizeTableExtensions = ".jpg,.png"
+	resolver, err := newSizeTableResolver(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		column, file, rule string
+	}{
+		{"S100-1", "S100.jpg", "style"},
+		{"S30012-2", "S3001.jpg", "prefix:5"},
+		{"K900-1", "kids_generic.jpg", "sheet:SizeTables"},
+		{"P7-1", "P7.png", "style"},
+		{"X1-1", "", ""},
+	} {
+		row := []string{"A", "B", tc.column, "ITEM"}
+		match, ok := resolver.resolve(row)
+		got := ""
+		if ok {
+			got = filepath.Base(match.Path)
+		}
+		if got != tc.file || match.Rule != tc.rule {
+			t.Errorf("%s: got %q via %q, want %q via %q", tc.column, got, match.Rule, tc.file, tc.rule)
+		}
+	}
+
+	// PNG size tables are stored as JPEG under the usual .jpg name
+	dst := filepath.Join(t.TempDir(), "ITEM_P7.jpg")
+	if err := copySizeTable(filepath.Join(cfg.SizeTablePath, "P7.png"), dst); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := jpeg.DecodeConfig(out); err != nil {
+		t.Errorf("converted size table is not a JPEG: %v", err)
+	}
+
+	for _, rules := range []string{"prefix", "sheet:Missing", "guess"} {
+		cfg.SizeTableRules = rules
+		if _, err := newSizeTableResolver(cfg, nil); err == nil {
+			t.Errorf("rules %q accepted", rules)
+		}
+	}
+}
+
+func TestSplitGeneratesMissingSizeTable(t *testing.T) {
+	cfg := newWorkPath(t)
+	os.Remove(filepath.Join(cfg.SizeTablePath, "S200.jpg"))
+	addSheet(t, cfg.WorkPath, "Measurements", "org", 0, [][]string{
+		{"Style", "Size", "Chest", "Length"},
+		{"S200", "S", "48", "66"},
+		{"S200", "M", "50", "68"},
+	})
+	cfg.SizeTableGenerate = true
+	cfg.SizeTableData = "Measurements"
+	cfg.SizeTableImageSize = "400x300"
+	cfg.SizeTableHeaderColor = "#8a1538"
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(cfg.WorkPath, "Brand_Season", "OUT", "ITEM2_S200.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 400 || b.Dy() != 300 {
+		t.Errorf("generated size table is %dx%d, want 400x300", b.Dx(), b.Dy())
+	}
+	// Somewhere in the header row there is brand color
+	found := false
+	for y := 0; y < 300 && !found; y += 2 {
+		r, g, b, _ := img.At(200, y).RGBA()
+		found = r>>8 > 0x70 && r>>8 < 0xa0 && g>>8 < 0x30 && b>>8 > 0x20 && b>>8 < 0x50
+	}
+	if !found {
+		t.Error("no header in the brand color")
+	}
+
+	// Data for the style is required
+	cfg.SizeTableData = "Missing"
+	if _, err := newSizeTableResolver(cfg, nil); err == nil {
+		t.Error("missing size table data sheet accepted")
+	}
+}
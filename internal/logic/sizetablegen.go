@@ -0,0 +1,273 @@
+package logic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"ahMakerdir/internal/config"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// sizeTableGenerator renders size tables from a measurements sheet or CSV for
+// styles without a size table image. The first row holds the headers, the
+// first column the style number (or column C as written) and the remaining
+// columns the table.
+type sizeTableGenerator struct {
+	headers []string
+	rows    map[string][][]string // style -> table rows
+	width   int
+	height  int
+	font    *sfnt.Font
+	header  color.Color // Header background, the header text is white
+	text    color.Color
+}
+
+func newSizeTableGenerator(cfg config.Config) (*sizeTableGenerator, error) {
+	g := &sizeTableGenerator{rows: make(map[string][][]string)}
+
+	var err error
+	if g.width, g.height, err = parseImageSize(cfg.SizeTableImageSize, 1000, 1000); err != nil {
+		return nil, fmt.Errorf("SizeTableImageSize: %v", err)
+	}
+	if g.header, err = parseHexColor(cfg.SizeTableHeaderColor, color.RGBA{0x33, 0x33, 0x33, 0xff}); err != nil {
+		return nil, fmt.Errorf("SizeTableHeaderColor: %v", err)
+	}
+	if g.text, err = parseHexColor(cfg.SizeTableTextColor, color.RGBA{0x33, 0x33, 0x33, 0xff}); err != nil {
+		return nil, fmt.Errorf("SizeTableTextColor: %v", err)
+	}
+	if g.font, err = loadFont(cfg.SizeTableFont); err != nil {
+		return nil, fmt.Errorf("SizeTableFont: %v", err)
+	}
+
+	rows, err := readSizeTableData(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		if i == 0 {
+			if len(row) > 1 {
+				g.headers = row[1:]
+			}
+			continue
+		}
+		if len(row) < 2 || strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+		style := strings.TrimSpace(row[0])
+		g.rows[style] = append(g.rows[style], row[1:])
+	}
+	if len(g.headers) == 0 {
+		return nil, fmt.Errorf("size table data %s has no header row", cfg.SizeTableData)
+	}
+	return g, nil
+}
+
+// readSizeTableData reads SizeTableData: a .csv/.tsv file (relative to the
+// work path) or the name of a sheet in the input workbook.
+func readSizeTableData(cfg config.Config) ([][]string, error) {
+	source := strings.TrimSpace(cfg.SizeTableData)
+	if source == "" {
+		return nil, fmt.Errorf("SizeTableGenerate needs SizeTableData, a sheet name or a .csv/.tsv file")
+	}
+
+	ext := strings.ToLower(filepath.Ext(source))
+	if ext == ".csv" || ext == ".tsv" {
+		path := source
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(strings.TrimSpace(cfg.WorkPath), path)
+		}
+		comma := ','
+		if ext == ".tsv" {
+			comma = '\t'
+		}
+		xlsx, err := readDelimited(path, comma)
+		if err != nil {
+			return nil, fmt.Errorf("size table data: %w", err)
+		}
+		defer xlsx.Close()
+		return xlsx.GetRows("Sheet1")
+	}
+
+	_, xlsx, err := openWorkbook(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer xlsx.Close()
+	name, err := resolveSheet(xlsx, source)
+	if err != nil {
+		return nil, fmt.Errorf("size table data: %w", err)
+	}
+	return xlsx.GetRows(name)
+}
+
+// has reports whether there is data for the row.
+func (g *sizeTableGenerator) has(row []string) bool {
+	return len(g.table(row)) > 0
+}
+
+func (g *sizeTableGenerator) table(row []string) [][]string {
+	if rows, ok := g.rows[strings.TrimSpace(row[2])]; ok {
+		return rows
+	}
+	return g.rows[strings.TrimSpace(styleNo(row))]
+}
+
+// generate renders the size table of the row to dst as JPEG.
+func (g *sizeTableGenerator) generate(row []string, dst string) error {
+	rows := g.table(row)
+	if len(rows) == 0 {
+		return fmt.Errorf("no size table data for %s", row[2])
+	}
+	img := g.render(strings.TrimSpace(styleNo(row)), rows)
+	return imaging.Save(img, dst, imaging.JPEGQuality(95))
+}
+
+func (g *sizeTableGenerator) render(title string, rows [][]string) image.Image {
+	cols := len(g.headers)
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, g.width, g.height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	margin := g.width / 16
+	tableW := g.width - 2*margin
+	colW := tableW / cols
+	tableW = colW * cols
+	left := (g.width - tableW) / 2
+
+	// Title takes two row heights, the table the rest, rows at most 1/8 of the height
+	rowH := (g.height - 2*margin) / (len(rows) + 3)
+	if limit := g.height / 8; rowH > limit {
+		rowH = limit
+	}
+	top := (g.height - rowH*(len(rows)+3)) / 2
+
+	g.drawText(img, title, image.Rect(left, top, left+tableW, top+2*rowH), float64(rowH)*0.8, g.text)
+	top += 2 * rowH
+
+	grid := color.RGBA{0xcc, 0xcc, 0xcc, 0xff}
+	headerText := color.White
+	draw.Draw(img, image.Rect(left, top, left+tableW, top+rowH), &image.Uniform{g.header}, image.Point{}, draw.Src)
+	for c := 0; c < cols; c++ {
+		cell := image.Rect(left+c*colW, top, left+(c+1)*colW, top+rowH)
+		g.drawText(img, cellValue(g.headers, c), cell, float64(rowH)*0.45, headerText)
+	}
+	for r, row := range rows {
+		y := top + (r+1)*rowH
+		for c := 0; c < cols; c++ {
+			cell := image.Rect(left+c*colW, y, left+(c+1)*colW, y+rowH)
+			g.drawText(img, cellValue(row, c), cell, float64(rowH)*0.45, g.text)
+		}
+	}
+
+	// Grid lines
+	bottom := top + (len(rows)+1)*rowH
+	for r := 0; r <= len(rows)+1; r++ {
+		y := top + r*rowH
+		draw.Draw(img, image.Rect(left, y, left+tableW+1, y+1), &image.Uniform{grid}, image.Point{}, draw.Src)
+	}
+	for c := 0; c <= cols; c++ {
+		x := left + c*colW
+		draw.Draw(img, image.Rect(x, top, x+1, bottom+1), &image.Uniform{grid}, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func cellValue(row []string, i int) string {
+	if i < len(row) {
+		return strings.TrimSpace(row[i])
+	}
+	return ""
+}
+
+// drawText centers text in rect, shrinking the font until it fits.
+func (g *sizeTableGenerator) drawText(img draw.Image, text string, rect image.Rectangle, size float64, c color.Color) {
+	if text == "" {
+		return
+	}
+	for ; size >= 6; size *= 0.9 {
+		face, err := opentype.NewFace(g.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return
+		}
+		d := &font.Drawer{Dst: img, Src: &image.Uniform{c}, Face: face}
+		width := d.MeasureString(text).Ceil()
+		if width > rect.Dx()*9/10 && size*0.9 >= 6 {
+			face.Close()
+			continue
+		}
+		m := face.Metrics()
+		ascent, descent := m.Ascent.Ceil(), m.Descent.Ceil()
+		x := rect.Min.X + (rect.Dx()-width)/2
+		y := rect.Min.Y + (rect.Dy()+ascent-descent)/2
+		d.Dot = fixed.P(x, y)
+		d.DrawString(text)
+		face.Close()
+		return
+	}
+}
+
+// loadFont reads a TrueType/OpenType font or the first font of a collection
+// (.ttc). Empty uses the built-in Go font, which has no CJK glyphs.
+func loadFont(path string) (*sfnt.Font, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return opentype.Parse(goregular.TTF)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if f, err := opentype.Parse(data); err == nil {
+		return f, nil
+	}
+	collection, err := opentype.ParseCollection(data)
+	if err != nil {
+		return nil, err
+	}
+	return collection.Font(0)
+}
+
+// parseImageSize reads WIDTHxHEIGHT, empty returns the defaults.
+func parseImageSize(s string, defWidth, defHeight int) (int, int, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return defWidth, defHeight, nil
+	}
+	w, h, ok := strings.Cut(s, "x")
+	width, errW := strconv.Atoi(strings.TrimSpace(w))
+	height, errH := strconv.Atoi(strings.TrimSpace(h))
+	if !ok || errW != nil || errH != nil || width < 10 || height < 10 {
+		return 0, 0, fmt.Errorf("%q is not a size like 1000x1000", s)
+	}
+	return width, height, nil
+}
+
+// parseHexColor reads #RRGGBB, empty returns def.
+func parseHexColor(s string, def color.Color) (color.Color, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 6 {
+		return nil, fmt.Errorf("%q is not a color like #336699", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
@@ -0,0 +1,249 @@
+package logic
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ahMakerdir/internal/config"
+)
+
+// sourceIndex lists the source folders (ColorPicPath, SizeTablePath) once per
+// run, since they are usually on a network share where every os.Stat is a
+// round trip. With SourceIndexCache the listings are kept between runs and
+// read again only when the folder's modification time changes; with
+// SourceMirrorPath the files actually used are also kept in a local folder.
+// Whether a mirror copy is current is decided from the size and time in the
+// listing, so a file replaced without its folder changing needs the cache
+// file deleted.
+type sourceIndex struct {
+	lookups   map[string]*fileLookup
+	stamps    map[string]fileStamp // Source path -> size and time as listed
+	cachePath string
+	cache     map[string]cachedListing // dir -> listing
+	changed   bool
+	mirror    string
+	log       func(string)
+}
+
+type cachedListing struct {
+	ModTime time.Time            `json:"mod_time"`
+	Names   []string             `json:"names"`
+	Files   map[string]fileStamp `json:"files"`
+}
+
+type fileStamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func newSourceIndex(cfg config.Config, log func(string)) *sourceIndex {
+	x := &sourceIndex{
+		lookups: make(map[string]*fileLookup),
+		stamps:  make(map[string]fileStamp),
+		cache:   make(map[string]cachedListing),
+		mirror:  strings.TrimSpace(cfg.SourceMirrorPath),
+		log:     log,
+	}
+	if path := strings.TrimSpace(cfg.SourceIndexCache); path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(strings.TrimSpace(cfg.WorkPath), path)
+		}
+		x.cachePath = path
+		if data, err := os.ReadFile(path); err == nil {
+			if err := json.Unmarshal(data, &x.cache); err != nil {
+				log(fmt.Sprintf("Warning: Ignoring unreadable source index %s: %v", path, err))
+				x.cache = make(map[string]cachedListing)
+			}
+		}
+	}
+	return x
+}
+
+// lookup returns the listing of dir, reading the folder at most once per run.
+// A nil index lists the folder every time.
+func (x *sourceIndex) lookup(dir string) *fileLookup {
+	dir = strings.TrimSpace(dir)
+	if x == nil {
+		return newFileLookup(dir)
+	}
+	if l, ok := x.lookups[dir]; ok {
+		return l
+	}
+
+	var files map[string]fileStamp
+	if dir != "" {
+		var dirInfo os.FileInfo
+		if x.cachePath != "" {
+			dirInfo, _ = os.Stat(dir)
+		}
+		// Listings of older versions have no file sizes and times and are read again
+		if cached, ok := x.cache[dir]; ok && dirInfo != nil && cached.ModTime.Equal(dirInfo.ModTime()) && cached.Files != nil {
+			files = cached.Files
+			x.log(fmt.Sprintf("Using cached listing of %s (%d files)", dir, len(files)))
+		} else if listed, err := listFileStamps(dir); err == nil {
+			files = listed
+			x.log(fmt.Sprintf("Listed %d files in %s", len(files), dir))
+			if dirInfo != nil {
+				x.cache[dir] = cachedListing{ModTime: dirInfo.ModTime(), Names: sortedNames(files), Files: files}
+				x.changed = true
+			}
+		}
+	}
+	for name, stamp := range files {
+		x.stamps[filepath.Join(dir, name)] = stamp
+	}
+	l := fileLookupOf(dir, sortedNames(files))
+	x.lookups[dir] = l
+	return l
+}
+
+// listFileStamps returns the size and modification time of the files in dir.
+func listFileStamps(dir string) (map[string]fileStamp, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]fileStamp)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed while listing
+		}
+		files[entry.Name()] = fileStamp{Size: info.Size(), ModTime: info.ModTime()}
+	}
+	return files, nil
+}
+
+func sortedNames(files map[string]fileStamp) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mirrorPath returns where the copy of src is kept in SourceMirrorPath.
+func (x *sourceIndex) mirrorPath(src string) string {
+	// One folder per source folder so equal names from two folders do not clash
+	sum := sha1.Sum([]byte(filepath.Dir(src)))
+	return filepath.Join(x.mirror, hex.EncodeToString(sum[:4]), filepath.Base(src))
+}
+
+// mirrored returns the mirror copy of src when it has the size and time src
+// was listed with. Nothing is read from the source folder.
+func (x *sourceIndex) mirrored(src string) (string, bool) {
+	if x == nil || x.mirror == "" {
+		return "", false
+	}
+	stamp, ok := x.stamps[src]
+	if !ok {
+		return "", false
+	}
+	dst := x.mirrorPath(src)
+	m, err := os.Stat(dst)
+	return dst, err == nil && m.Size() == stamp.Size && m.ModTime().Unix() == stamp.ModTime.Unix()
+}
+
+// local returns a path to read src from: its mirror copy, refreshed first
+// when it is missing or out of date, or src itself without a mirror.
+func (x *sourceIndex) local(src string) string {
+	if m, ok := x.mirrored(src); ok {
+		return m
+	}
+	if x == nil || x.mirror == "" {
+		return src
+	}
+	if _, ok := x.stamps[src]; !ok {
+		return src
+	}
+	if err := x.copyFile(src, ""); err != nil {
+		return src
+	}
+	return x.mirrorPath(src)
+}
+
+// copyFile copies src to dst, from the mirror when its copy is current.
+// Otherwise src is read once and written to dst and the mirror together.
+// An empty dst only refreshes the mirror.
+func (x *sourceIndex) copyFile(src, dst string) error {
+	if m, ok := x.mirrored(src); ok {
+		if dst == "" {
+			return nil
+		}
+		return copyFile(m, dst)
+	}
+	if x == nil || x.mirror == "" {
+		return copyFile(src, dst)
+	}
+	stamp, listed := x.stamps[src]
+	if !listed {
+		return copyFile(src, dst)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var writers []io.Writer
+	if dst != "" {
+		out, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		writers = append(writers, out)
+	}
+	mirrorPath := x.mirrorPath(src)
+	var mirror *os.File
+	if err := os.MkdirAll(filepath.Dir(mirrorPath), 0755); err != nil {
+		x.log(fmt.Sprintf("Warning: Failed to create mirror folder: %v", err))
+	} else if mirror, err = os.Create(mirrorPath); err != nil {
+		x.log(fmt.Sprintf("Warning: Failed to mirror %s: %v", src, err))
+	} else {
+		writers = append(writers, mirror)
+	}
+	if len(writers) == 0 {
+		return fmt.Errorf("failed to mirror %s", src)
+	}
+
+	_, err = io.Copy(io.MultiWriter(writers...), in)
+	if mirror != nil {
+		mirror.Close()
+		if err != nil {
+			os.Remove(mirrorPath)
+		} else {
+			os.Chtimes(mirrorPath, stamp.ModTime, stamp.ModTime)
+		}
+	}
+	return err
+}
+
+// save writes the listings back to SourceIndexCache when they changed.
+func (x *sourceIndex) save() {
+	if x == nil || x.cachePath == "" || !x.changed {
+		return
+	}
+	data, err := json.MarshalIndent(x.cache, "", "  ")
+	if err == nil {
+		err = os.WriteFile(x.cachePath, data, 0644)
+	}
+	if err != nil {
+		x.log(fmt.Sprintf("Warning: Failed to save source index: %v", err))
+		return
+	}
+	x.changed = false
+}
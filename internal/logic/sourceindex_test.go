@@ -0,0 +1,94 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceIndexCache(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.SourceIndexCache = "source_index.json"
+
+	x := newSourceIndex(cfg, testLog(t))
+	if x.lookup(cfg.ColorPicPath).find("sw1", colorPicExtensions) == "" {
+		t.Fatal("sw1 not listed")
+	}
+	x.save()
+
+	// A file added without the folder time changing is not seen: the cached
+	// listing was used instead of reading the folder
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(cfg.ColorPicPath, stamp, stamp)
+	x = newSourceIndex(cfg, testLog(t))
+	x.lookup(cfg.ColorPicPath)
+	x.save()
+	if err := os.WriteFile(filepath.Join(cfg.ColorPicPath, "sw2.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(cfg.ColorPicPath, stamp, stamp)
+	if newSourceIndex(cfg, testLog(t)).lookup(cfg.ColorPicPath).find("sw2", colorPicExtensions) != "" {
+		t.Error("cached listing not used")
+	}
+
+	// Once the folder changes it is listed again
+	later := stamp.Add(time.Minute)
+	os.Chtimes(cfg.ColorPicPath, later, later)
+	if newSourceIndex(cfg, testLog(t)).lookup(cfg.ColorPicPath).find("sw2", colorPicExtensions) == "" {
+		t.Error("changed folder not listed again")
+	}
+}
+
+func TestSourceMirror(t *testing.T) {
+	cfg := newWorkPath(t)
+	cfg.SourceMirrorPath = filepath.Join(t.TempDir(), "mirror")
+	x := newSourceIndex(cfg, testLog(t))
+
+	src := filepath.Join(cfg.SizeTablePath, "S100.jpg")
+	x.lookup(cfg.SizeTablePath)
+	dst := filepath.Join(t.TempDir(), "S100.jpg")
+	if err := x.copyFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	local, ok := x.mirrored(src)
+	if !ok || !strings.HasPrefix(local, cfg.SourceMirrorPath) {
+		t.Fatalf("mirrored(%s) = %s, %v, want a current copy in the mirror", src, local, ok)
+	}
+	want, _ := os.ReadFile(src)
+	for _, path := range []string{dst, local} {
+		if got, _ := os.ReadFile(path); string(got) != string(want) {
+			t.Errorf("%s differs from the source", path)
+		}
+	}
+
+	// Freshness comes from the listing made at startup, a later change of the
+	// source is seen by the next run
+	if err := os.WriteFile(src, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(x.local(src)); string(got) != string(want) {
+		t.Errorf("mirror copy changed within the run: %q", got)
+	}
+	x = newSourceIndex(cfg, testLog(t))
+	x.lookup(cfg.SizeTablePath)
+	if _, ok := x.mirrored(src); ok {
+		t.Error("mirror copy of the changed source counted as current")
+	}
+	if got, _ := os.ReadFile(x.local(src)); string(got) != "new" {
+		t.Errorf("mirror copy not refreshed: %q", got)
+	}
+
+	// Split copies size tables through the mirror
+	cfg.SourceIndexCache = "source_index.json"
+	if _, err := RunSplit(cfg, testLog(t)); err != nil && !strings.Contains(err.Error(), "size table") {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.WorkPath, "source_index.json")); err != nil {
+		t.Errorf("source index not saved: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(cfg.WorkPath, "Brand_Season", "OUT", "ITEM1_S100.jpg")); string(got) != "new" {
+		t.Errorf("size table copy = %q", got)
+	}
+}
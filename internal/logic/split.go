@@ -1,7 +1,6 @@
 package logic
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -9,10 +8,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"ahMakerdir/internal/config"
-
-	"github.com/xuri/excelize/v2"
+	"ahMakerdir/internal/manifest"
 )
 
 // RunSplit executes the image splitting logic
@@ -20,285 +19,271 @@ func RunSplit(cfg config.Config, progress func(string)) ([]string, error) {
 	progress("Starting Split Process...")
 
 	dirPath := strings.TrimSpace(cfg.WorkPath)
-	specPath := strings.TrimSpace(cfg.SizeTablePath)
 
 	// Scan directory for Excel and Image folders
-	entries, err := os.ReadDir(dirPath)
+	excelFile, sheets, err := loadSheets(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read work path: %w", err)
+		return nil, err
 	}
+	progress(fmt.Sprintf("Found Excel file: %s", excelFile))
 
-	var excelFile string
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.Contains(entry.Name(), ".xlsx") && !strings.HasPrefix(entry.Name(), "~$") {
-			excelFile = entry.Name()
-			break
+	// Read Images, each sheet can have its own picture directory
+	sheetImages := make([][]string, len(sheets))
+	imageCounts := make([]int, len(sheets))
+	for i, sheet := range sheets {
+		images, err := scanImages(filepath.Join(dirPath, sheet.PictureDir))
+		if err != nil {
+			return nil, err
 		}
+		sheetImages[i] = images
+		imageCounts[i] = len(images)
+		progress(fmt.Sprintf("Sheet %s: found %d images in %s", sheet.Name, len(images), sheet.PictureDir))
 	}
 
-	if excelFile == "" {
-		return nil, fmt.Errorf("no Excel file found in %s", dirPath)
+	// Source folders are listed once for validation and the copies below
+	sources := newSourceIndex(cfg, progress)
+	defer sources.save()
+	sizeTables, err := newSizeTableResolver(cfg, sources)
+	if err != nil {
+		return nil, err
 	}
-	progress(fmt.Sprintf("Found Excel file: %s", excelFile))
-
-	// Read Images
-	imagePath := filepath.Join(dirPath, cfg.PictureDirName)
-	imageFiles, err := scanDirSort(imagePath)
+	swatches, err := newSwatchGenerator(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read image directory: %w", err)
+		return nil, err
 	}
-
-	// Filter for images
-	var imagePicArr []string
-	for _, file := range imageFiles {
-		lowerFile := strings.ToLower(file)
-		if strings.HasSuffix(lowerFile, ".jpg") || strings.HasSuffix(lowerFile, ".png") {
-			imagePicArr = append(imagePicArr, file)
-		}
+	filenames, err := parseFilenameTemplate(cfg.FilenameTemplate)
+	if err != nil {
+		return nil, err
 	}
-	progress(fmt.Sprintf("Found %d images", len(imagePicArr)))
-
-	// Read Excel
-	xlsx, err := excelize.OpenFile(filepath.Join(dirPath, excelFile))
+	rules, err := newImageRules(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open Excel file: %w", err)
+		return nil, err
 	}
-	defer xlsx.Close()
+	colorPics := sources.lookup(cfg.ColorPicPath)
 
-	sheetName := xlsx.GetSheetName(xlsx.GetActiveSheetIndex())
-	rows, err := xlsx.GetRows(sheetName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get rows: %w", err)
+	// Stop before any folder is created when a sheet has errors
+	issues := validateSheets(cfg, sheets, imageCounts, sizeTables, colorPics, rules)
+	if errorCount := logValidation(issues, progress); errorCount > 0 {
+		return nil, fmt.Errorf("Excel validation failed with %d errors, nothing was created", errorCount)
 	}
+	logSizeTables(sizeTables, sheets, progress)
+	logImagePlan(rules, sheets, progress)
 
-	// Skip header if necessary? Original code didn't seem to skip explicitly,
-	// but usually row 0 is header. Original code: `for index, row := range rows`
-	// and used `index` logic. It seems row 0 IS data in original code or they just processed it.
-	// Wait, original code: `for _, row := range rows` (line 114) to count total.
-	// Then `for index, row := range rows` (line 131).
-	// Let's assume all rows are data for now to match original behavior,
-	// OR check if the first row looks like a header.
-	// Original code logic: `i, err := strconv.Atoi(row[8])`. If row[8] is "Count" (header), this would fail.
-	// So likely the Excel file has NO header or the user knows to not include it.
-	// However, `strconv.Atoi` error in original code just printed "轉換失敗!!" and continued.
-
-	begin := 0
-	end := 0
 	var smallDirs []string
 	var failSizeTable []string
-	manifest := make(map[string]ImageMetadata)
-
-	for index, row := range rows {
-		if len(row) < 9 {
-			continue // Skip invalid rows
-		}
-
-		step, err := strconv.Atoi(row[8])
-		if err != nil {
-			progress(fmt.Sprintf("Row %d: Invalid step count (col I), skipping.", index+1))
-			continue
-		}
-
-		if index == 0 {
-			end = step - 1
-		} else {
-			end = end + step
-		}
+	runManifest := manifest.New(time.Now().Format("20060102_150405"))
+	names := newItemNamer(filenames)
+
+	for sheetIndex, sheet := range sheets {
+		imagePath := filepath.Join(dirPath, sheet.PictureDir)
+		imagePicArr := sheetImages[sheetIndex]
+		begin := 0
+		end := 0
+		for index, row := range sheet.Rows {
+			if len(row) < 9 {
+				continue // Skip invalid rows
+			}
 
-		// Directory paths
-		// row[0]: Folder Name 1
-		// row[1]: Folder Name 2
-		// row[3]: Item ID?
-		// row[6]: Color?
-
-		// Clean row[6]
-		row[6] = strings.ReplaceAll(row[6], "/", "")
-
-		level1 := filepath.Join(dirPath, row[0]+"_"+row[1])
-		level2 := filepath.Join(level1, row[3]+"_"+row[6])
-		level3 := filepath.Join(level2, "BIG")
-		level4 := filepath.Join(level2, "SMALL")
-		level15 := filepath.Join(level1, "OUT")
-
-		ensureDir(level1)
-		ensureDir(level2)
-		ensureDir(level3)
-		ensureDir(level4)
-		ensureDir(level15)
-
-		// Copy Size Table
-		styleNo := strings.Split(row[2], "-")[0]
-		styleNoPath := filepath.Join(specPath, styleNo+".jpg")
-		destSizeTable := filepath.Join(level15, row[3]+"_"+styleNo+".jpg")
-
-		if err := copyFile(styleNoPath, destSizeTable); err != nil {
-			failSizeTable = append(failSizeTable, fmt.Sprintf("Failed to copy size table: %s", styleNoPath))
-		}
+			step, err := strconv.Atoi(row[8])
+			if err != nil {
+				progress(fmt.Sprintf("Row %d: Invalid step count (col I), skipping.", index+1))
+				continue
+			}
 
+			// Counted from begin so a skipped header row does not shift the images
+			end = begin + step - 1
+
+			// Directory paths
+			// row[0]: Folder Name 1
+			// row[1]: Folder Name 2
+			// row[3]: Item ID?
+			// row[6]: Color?
+
+			// Clean row[6]
+			row[6] = strings.ReplaceAll(row[6], "/", "")
+
+			// Base of the file names, unique when several rows share the item code
+			baseName := names.name(row[3], row[6], "", step)
+
+			level1 := filepath.Join(dirPath, row[0]+"_"+row[1])
+			level2 := filepath.Join(level1, row[3]+"_"+row[6])
+			level3 := filepath.Join(level2, "BIG")
+			level4 := filepath.Join(level2, "SMALL")
+			level15 := filepath.Join(level1, "OUT")
+
+			ensureDir(level1)
+			ensureDir(level2)
+			ensureDir(level3)
+			ensureDir(level4)
+			ensureDir(level15)
+
+			// Copy Size Table
+			destSizeTable := filepath.Join(level15, baseName+"_"+styleNo(row)+".jpg")
+
+			item := runManifest.AddItem(&manifest.Item{
+				Code:     row[3],
+				Name:     baseName,
+				Sheet:    sheet.Label,
+				ExcelRow: index + 1,
+				Folder:   filepath.Base(level1),
+				Color:    row[6],
+			})
+			var rowWarnings []string
+			if match, ok := sizeTables.resolve(row); !ok {
+				failSizeTable = append(failSizeTable, fmt.Sprintf("Size table not found: %s (%s)%s", row[2], row[3], sizeTables.suggestion(row)))
+				rowWarnings = append(rowWarnings, fmt.Sprintf("Size table not found: %s%s", row[2], sizeTables.suggestion(row)))
+			} else if err := sizeTables.write(match, row, destSizeTable); err != nil {
+				failSizeTable = append(failSizeTable, fmt.Sprintf("Failed to write size table for %s: %v", row[3], err))
+				rowWarnings = append(rowWarnings, fmt.Sprintf("Failed to write size table: %v", err))
+			} else if match.Generate {
+				progress(fmt.Sprintf("Generated size table %s", filepath.Base(destSizeTable)))
+			}
 
-		// Copy Images
-		count := 1
-		extraCount := 1
-		for i := begin; i <= end && i < len(imagePicArr); i++ {
-			originalName := imagePicArr[i]
-			srcImg := filepath.Join(imagePath, originalName)
-			
-			// New filename base
-			newFilename := fmt.Sprintf("%s_0%d.jpg", row[3], count)
-
-			// BIG
-			destBig := filepath.Join(level3, newFilename)
-			copyFile(srcImg, destBig)
-
-			// SMALL
-			destSmall := filepath.Join(level4, newFilename)
-			copyFile(srcImg, destSmall)
-
-			// OUT
-			destOut := filepath.Join(level15, newFilename)
-			copyFile(srcImg, destOut)
-
-			// Calculate IsDef
-			// Col J (index 9) -> IsDef = 1
-			// Col K (index 10) -> IsDef = 2
-			isDef := 0
-			
-			// Helper to get value from column
-			getColVal := func(colIndex int) int {
-				if len(row) > colIndex {
-					if val, err := strconv.Atoi(row[colIndex]); err == nil {
-						return val
+			// Color pic (Col L / Index 11), resolved once for the row and
+			// referenced by all of its images
+			colorPicName, colorPicSource, colorPicGenerated := "", "", false
+			if colorPicInput := strings.TrimSpace(cellValue(row, 11)); colorPicInput != "" {
+				// The name as given, or with .jpg then .png when it has no extension
+				srcColorPic := colorPics.find(colorPicInput, colorPicExtensions)
+				if srcColorPic == "" {
+					progress(fmt.Sprintf("Warning: Color pic not found in %s: %s%s", cfg.ColorPicPath, colorPicInput, colorPics.suggestion(colorPicInput)))
+					rowWarnings = append(rowWarnings, fmt.Sprintf("Color pic not found: %s%s", colorPicInput, colorPics.suggestion(colorPicInput)))
+				} else {
+					// Copy to SMALL
+					// Target name: baseName (ItemCode, see itemNamer) + "_Color" + ext
+					destColorPicName := fmt.Sprintf("%s_Color%s", baseName, filepath.Ext(srcColorPic))
+					if err := sources.copyFile(srcColorPic, filepath.Join(level4, destColorPicName)); err != nil {
+						progress(fmt.Sprintf("Warning: Failed to copy color pic: %v", err))
+					} else {
+						colorPicName, colorPicSource = destColorPicName, filepath.Base(srcColorPic)
 					}
 				}
-				return -1 // Not found or invalid
 			}
 
-			valJ := getColVal(9)
-			valK := getColVal(10)
-
-			if count == valJ {
-				isDef = 1
-			} else if count == valK {
-				isDef = 2
-			}
-
-			// Handle Color Pic (Col L / Index 11)
-			colorPicName := ""
-			if len(row) > 11 {
-				colorPicInput := strings.TrimSpace(row[11])
-				if colorPicInput != "" {
-						
-						// Determine extension and source path
-						ext := filepath.Ext(colorPicInput)
-						srcColorPic := ""
-						
-						if ext != "" {
-							// User provided extension
-							srcColorPic = filepath.Join(cfg.ColorPicPath, colorPicInput)
-							if _, err := os.Stat(srcColorPic); os.IsNotExist(err) {
-								progress(fmt.Sprintf("Warning: Color pic not found: %s", srcColorPic))
-								srcColorPic = ""
-							}
-						} else {
-							// No extension provided, try .jpg then .png
-							tryJpg := filepath.Join(cfg.ColorPicPath, colorPicInput+".jpg")
-							tryPng := filepath.Join(cfg.ColorPicPath, colorPicInput+".png")
-							
-							if _, err := os.Stat(tryJpg); err == nil {
-								srcColorPic = tryJpg
-								ext = ".jpg"
-							} else if _, err := os.Stat(tryPng); err == nil {
-								srcColorPic = tryPng
-								ext = ".png"
-							} else {
-								progress(fmt.Sprintf("Warning: Color pic not found (tried .jpg/.png): %s", colorPicInput))
-							}
-						}
-
-						if srcColorPic != "" {
-							// Copy to SMALL
-							// Target name: row[3] (ItemCode) + "_Color" + ext
-							destColorPicName := fmt.Sprintf("%s_Color%s", row[3], ext)
-							destColorPicPath := filepath.Join(level4, destColorPicName)
-							
-							if err := copyFile(srcColorPic, destColorPicPath); err != nil {
-								progress(fmt.Sprintf("Warning: Failed to copy color pic: %v", err))
-							} else {
-								colorPicName = destColorPicName
-							}
-						}
+			// Generate a swatch when column L gave none
+			if colorPicName == "" && swatches != nil {
+				name := fmt.Sprintf("%s_Color.png", baseName)
+				defaultImage := rowDefaultImage(rules, row, imagePath, imagePicArr, begin, end)
+				if err := swatches.write(row, defaultImage, filepath.Join(level4, name)); err != nil {
+					progress(fmt.Sprintf("Warning: Failed to generate swatch for %s: %v", row[3], err))
+					rowWarnings = append(rowWarnings, fmt.Sprintf("Failed to generate swatch: %v", err))
+				} else {
+					colorPicName, colorPicGenerated = name, true
+					if swatches.mode == SwatchSample {
+						colorPicSource = filepath.Base(defaultImage)
+					}
+					progress(fmt.Sprintf("Generated swatch %s", name))
 				}
 			}
 
-			// Record to manifest
-			manifest[newFilename] = ImageMetadata{
-				ExcelColD:        row[3],
-				Sort:             count,
-				IsDef:            isDef,
-				ColorPicFilename: colorPicName,
+			if colorPicName != "" {
+				colorFile := &manifest.File{Name: colorPicName, Type: manifest.TypeColor, Generated: colorPicGenerated, SourceImage: colorPicSource}
+				addRendition(colorFile, manifest.RenditionSmall, dirPath, filepath.Join(level4, colorPicName), progress)
+				item.Files = append(item.Files, colorFile)
 			}
 
-			// Duplicate image if IsDef is 1 or 2 (User Request)
-			if isDef == 1 || isDef == 2 {
-				ext := filepath.Ext(newFilename)
-				base := strings.TrimSuffix(newFilename, ext)
-				dupFilename := fmt.Sprintf("%s_01%s", base, ext)
+			// Copy Images
+			count := 1
+			extraCount := 1
+			for i := begin; i <= end && i < len(imagePicArr); i++ {
+				originalName := imagePicArr[i]
+				srcImg := filepath.Join(imagePath, originalName)
+
+				// Calculate IsDef from DefaultImageRules
+				// (by default col J (index 9) -> IsDef = 1, col K (index 10) -> IsDef = 2)
+				isDef := rules.isDef(row, count, step)
+
+				// New filename from FilenameTemplate
+				nameVars := filenameVars{Item: baseName, Code: row[3], Color: row[6], Seq: count, Ext: filepath.Ext(originalName), Role: roleImage}
+				if isDef > 0 {
+					nameVars.Role = roleDefault
+				}
+				newFilename := filenames.render(nameVars)
+
+				// BIG
+				destBig := filepath.Join(level3, newFilename)
+				copyFile(srcImg, destBig)
+
+				// SMALL
+				destSmall := filepath.Join(level4, newFilename)
+				copyFile(srcImg, destSmall)
+
+				// OUT
+				destOut := filepath.Join(level15, newFilename)
+				copyFile(srcImg, destOut)
+
+				// Record to manifest
+				imageFile := &manifest.File{Name: newFilename, Type: manifest.TypeImage, Sort: count, IsDef: isDef, ColorPic: colorPicName, SourceImage: originalName}
+				addRendition(imageFile, manifest.RenditionBig, dirPath, destBig, progress)
+				addRendition(imageFile, manifest.RenditionSmall, dirPath, destSmall, progress)
+				addRendition(imageFile, manifest.RenditionOut, dirPath, destOut, progress)
+				item.Files = append(item.Files, imageFile)
+
+				// Duplicate image per DuplicateRules (by default when IsDef is 1 or 2)
+				if rules.duplicates(isDef) {
+					nameVars.Role = roleDuplicate
+					dupFilename := filenames.render(nameVars)
 				
-				// Copy to SMALL (level4)
-				dupDest := filepath.Join(level4, dupFilename)
-				copyFile(srcImg, dupDest)
-
-				// Calculate sort for duplicate
-				dupSort := step + extraCount
-				extraCount++
-
-				// Add to manifest with IsDef = 0
-				manifest[dupFilename] = ImageMetadata{
-					ExcelColD:        row[3],
-					Sort:             dupSort,
-					IsDef:            0,
-					ColorPicFilename: colorPicName,
+					// Copy to SMALL (level4)
+					dupDest := filepath.Join(level4, dupFilename)
+					copyFile(srcImg, dupDest)
+
+					// Calculate sort for duplicate
+					dupSort := rules.duplicateSort(count, step, extraCount)
+					extraCount++
+
+					// Add to manifest with the IsDef of copies (0 by default)
+					dupFile := &manifest.File{Name: dupFilename, Type: manifest.TypeImage, Sort: dupSort, IsDef: rules.copyIsDef, ColorPic: colorPicName, SourceImage: originalName}
+					addRendition(dupFile, manifest.RenditionSmall, dirPath, dupDest, progress)
+					item.Files = append(item.Files, dupFile)
 				}
+
+				count++
 			}
 
-			count++
+			// Keep the row's problems with its images for the run report
+			item.Warnings = rowWarnings
+
+			smallDirs = append(smallDirs, level4)
+			begin = begin + step
+			progress(fmt.Sprintf("Processed %s", row[3]))
 		}
+	}
 
-		smallDirs = append(smallDirs, level4)
-		begin = begin + step
-		progress(fmt.Sprintf("Processed %s", row[3]))
+	// Save Manifest (Standard), also when size tables are missing so the
+	// images that were split can still be uploaded and reported on
+	if err := runManifest.Save(manifest.Path(dirPath)); err != nil {
+		progress(fmt.Sprintf("Warning: Failed to save manifest.json: %v", err))
 	}
 
 	if len(failSizeTable) > 0 {
 		return smallDirs, fmt.Errorf("completed with errors: %v", failSizeTable)
 	}
 
-	// Save Manifest (Standard)
-	manifestPath := filepath.Join(dirPath, "manifest.json")
-	manifestData, err := json.MarshalIndent(manifest, "", "  ")
-	if err == nil {
-		if err := os.WriteFile(manifestPath, manifestData, 0644); err != nil {
-			progress(fmt.Sprintf("Warning: Failed to save manifest.json: %v", err))
-		}
-	} else {
-		progress(fmt.Sprintf("Warning: Failed to marshal manifest: %v", err))
-	}
-
 	progress("Split Process Complete.")
 	return smallDirs, nil
 }
 
-// ImageMetadata holds info for API upload
-type ImageMetadata struct {
-	ExcelColD string `json:"excel_col_d"`
-	FtpPath   string `json:"ftp_path,omitempty"`
-	Sort      int    `json:"sort"`
-	IsDef     int    `json:"is_def"`
-	ColorPicFilename string `json:"color_pic_filename,omitempty"`
-}
-
 // Helper functions
 
+// scanImages returns the .jpg and .png files of the picture directory in the
+// order they are assigned to rows.
+func scanImages(imagePath string) ([]string, error) {
+	imageFiles, err := scanDirSort(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image directory: %w", err)
+	}
+
+	var imagePicArr []string
+	for _, file := range imageFiles {
+		lowerFile := strings.ToLower(file)
+		if strings.HasSuffix(lowerFile, ".jpg") || strings.HasSuffix(lowerFile, ".png") {
+			imagePicArr = append(imagePicArr, file)
+		}
+	}
+	return imagePicArr, nil
+}
+
 func ensureDir(path string) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		os.MkdirAll(path, 0755)
@@ -0,0 +1,46 @@
+package logic
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestSplitHeaderRowKeepsImageAssignment(t *testing.T) {
+	cfg := newWorkPath(t)
+	xlsx, err := excelize.OpenFile(filepath.Join(cfg.WorkPath, "list.xlsx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xlsx.InsertRows("Sheet1", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	header := []interface{}{"Brand", "Season", "Size", "Item", "", "", "Color", "", "Images", "Default", "Second", "Color pic"}
+	if err := xlsx.SetSheetRow("Sheet1", "A1", &header); err != nil {
+		t.Fatal(err)
+	}
+	if err := xlsx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	xlsx.Close()
+
+	if _, err := RunSplit(cfg, testLog(t)); err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	// Earlier versions let a skipped header row shift the image ranges, giving
+	// ITEM1 three pictures, the last one shared with ITEM2
+	entries := readManifest(t, cfg.WorkPath)
+	for key, source := range map[string]string{
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_01.jpg"):  "a (1).jpg",
+		smallKey("Brand_Season", "ITEM1_Red", "ITEM1_02.jpg"):  "a (2).jpg",
+		smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_01.jpg"): "a (3).jpg",
+	} {
+		if m, ok := entries[key]; !ok || m.SourceImage != source {
+			t.Errorf("manifest[%s] = %+v, want source %s", key, m, source)
+		}
+	}
+	if _, ok := entries[smallKey("Brand_Season", "ITEM1_Red", "ITEM1_03.jpg")]; ok {
+		t.Error("ITEM1 got a third image")
+	}
+}
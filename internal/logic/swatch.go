@@ -0,0 +1,213 @@
+package logic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ahMakerdir/internal/config"
+
+	"github.com/disintegration/imaging"
+	"github.com/xuri/excelize/v2"
+)
+
+// Swatch generation modes for SwatchGenerate
+const (
+	SwatchSample = "sample" // Dominant color of SwatchRegion in the default (is_def=1) image
+	SwatchColumn = "column" // Hex code or color name in SwatchColorColumn
+)
+
+// swatchGenerator creates the <ItemCode>_Color swatch for rows whose column L
+// file is missing or empty.
+type swatchGenerator struct {
+	mode   string
+	region [4]float64 // x, y, width, height as fractions of the image
+	column int        // 0-based
+	width  int
+	height int
+}
+
+// newSwatchGenerator returns nil when SwatchGenerate is off.
+func newSwatchGenerator(cfg config.Config) (*swatchGenerator, error) {
+	mode := strings.ToLower(strings.TrimSpace(cfg.SwatchGenerate))
+	if mode == "" || mode == "off" {
+		return nil, nil
+	}
+	if mode != SwatchSample && mode != SwatchColumn {
+		return nil, fmt.Errorf("unknown SwatchGenerate %q, use %s or %s", cfg.SwatchGenerate, SwatchSample, SwatchColumn)
+	}
+
+	s := &swatchGenerator{mode: mode, region: [4]float64{0.4, 0.4, 0.2, 0.2}}
+	var err error
+	if s.width, s.height, err = parseImageSize(cfg.SwatchSize, 100, 100); err != nil {
+		return nil, fmt.Errorf("SwatchSize: %v", err)
+	}
+
+	if region := strings.TrimSpace(cfg.SwatchRegion); region != "" {
+		parts := strings.Split(region, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("SwatchRegion %q needs x,y,width,height as fractions, e.g. 0.4,0.4,0.2,0.2", region)
+		}
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil || v < 0 || v > 1 {
+				return nil, fmt.Errorf("SwatchRegion %q needs fractions between 0 and 1", region)
+			}
+			s.region[i] = v
+		}
+		if s.region[2] == 0 || s.region[3] == 0 {
+			return nil, fmt.Errorf("SwatchRegion %q has no area", region)
+		}
+	}
+
+	column := strings.TrimSpace(cfg.SwatchColorColumn)
+	if column == "" {
+		column = "G"
+	}
+	n, err := excelize.ColumnNameToNumber(column)
+	if err != nil {
+		return nil, fmt.Errorf("SwatchColorColumn: %v", err)
+	}
+	s.column = n - 1
+	return s, nil
+}
+
+// write renders the swatch of the row to dst. defaultImage is the row's
+// is_def=1 picture, used in sample mode.
+func (s *swatchGenerator) write(row []string, defaultImage, dst string) error {
+	var c color.Color
+	switch s.mode {
+	case SwatchSample:
+		if defaultImage == "" {
+			return fmt.Errorf("no default image to sample")
+		}
+		img, err := imaging.Open(defaultImage)
+		if err != nil {
+			return err
+		}
+		b := img.Bounds()
+		rect := image.Rect(
+			b.Min.X+int(s.region[0]*float64(b.Dx())),
+			b.Min.Y+int(s.region[1]*float64(b.Dy())),
+			b.Min.X+int((s.region[0]+s.region[2])*float64(b.Dx())),
+			b.Min.Y+int((s.region[1]+s.region[3])*float64(b.Dy())),
+		).Intersect(b)
+		if rect.Empty() {
+			return fmt.Errorf("SwatchRegion is outside the image")
+		}
+		c = dominantColor(img, rect)
+	case SwatchColumn:
+		value := ""
+		if s.column < len(row) {
+			value = strings.TrimSpace(row[s.column])
+		}
+		var ok bool
+		if c, ok = parseColorName(value); !ok {
+			return fmt.Errorf("%q is not a hex code or known color name", value)
+		}
+	}
+	return imaging.Save(imaging.New(s.width, s.height, c), dst)
+}
+
+// dominantColor returns the average of the most common color bucket in rect,
+// so a few highlights or seams do not shift the result.
+func dominantColor(img image.Image, rect image.Rectangle) color.Color {
+	type sum struct{ r, g, b, n int }
+	buckets := make(map[int]*sum)
+	var best *sum
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			r, g, b = r>>8, g>>8, b>>8
+			key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			s, ok := buckets[key]
+			if !ok {
+				s = &sum{}
+				buckets[key] = s
+			}
+			s.r += int(r)
+			s.g += int(g)
+			s.b += int(b)
+			s.n++
+			if best == nil || s.n > best.n {
+				best = s
+			}
+		}
+	}
+	return color.RGBA{uint8(best.r / best.n), uint8(best.g / best.n), uint8(best.b / best.n), 0xff}
+}
+
+// Color names accepted in column mode, English and the Chinese names used in the sheets
+var colorNames = map[string]uint32{
+	"black": 0x000000, "white": 0xffffff, "red": 0xd32f2f, "blue": 0x1e4fa3, "navy": 0x1f2a44,
+	"green": 0x2e7d32, "olive": 0x6b6b2a, "khaki": 0xc3b091, "yellow": 0xf5d033, "orange": 0xef7d22,
+	"pink": 0xf4a7b9, "purple": 0x6a3d9a, "brown": 0x6d4c41, "beige": 0xe8dcc4, "cream": 0xf5efe0,
+	"grey": 0x9e9e9e, "gray": 0x9e9e9e, "charcoal": 0x3c3c3c, "silver": 0xc0c0c0, "gold": 0xc9a227,
+	"wine": 0x722f37, "burgundy": 0x800020, "camel": 0xc19a6b, "ivory": 0xfffff0, "mint": 0xa8e6cf,
+	"navy blue": 0x1f2a44, "dark grey": 0x3c3c3c, "dark gray": 0x3c3c3c, "light grey": 0xcfcfcf,
+	"light gray": 0xcfcfcf, "wine red": 0x722f37, "army green": 0x6b6b2a, "off white": 0xf5efe0,
+	"黑": 0x000000, "白": 0xffffff, "紅": 0xd32f2f, "藍": 0x1e4fa3, "深藍": 0x1f2a44, "藏青": 0x1f2a44,
+	"綠": 0x2e7d32, "軍綠": 0x6b6b2a, "卡其": 0xc3b091, "黃": 0xf5d033, "橘": 0xef7d22, "粉": 0xf4a7b9,
+	"紫": 0x6a3d9a, "咖啡": 0x6d4c41, "棕": 0x6d4c41, "米": 0xe8dcc4, "灰": 0x9e9e9e, "深灰": 0x3c3c3c,
+	"淺灰": 0xcfcfcf, "銀": 0xc0c0c0, "金": 0xc9a227, "酒紅": 0x722f37, "駝": 0xc19a6b,
+}
+
+// parseColorName reads #RRGGBB / RRGGBB or a color name. Names may carry extra
+// words ("Navy Stripe", "深藍色"). English names match whole words only, so
+// "Redwood" is not red; Chinese names match anywhere. The longest known name
+// wins, then the one written first, so "Navy Blue" is navy blue.
+func parseColorName(value string) (color.Color, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return nil, false
+	}
+	// Without # a hex code needs a digit, so words like "beaded" stay names
+	if hex := strings.TrimPrefix(value, "#"); len(hex) == 6 && (hex != value || strings.ContainsAny(hex, "0123456789")) {
+		if v, err := strconv.ParseUint(hex, 16, 32); err == nil {
+			return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+		}
+	}
+	words := strings.FieldsFunc(value, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	text := " " + strings.Join(words, " ") + " "
+	best, bestAt := "", -1
+	for name := range colorNames {
+		var at int
+		if name[0] < utf8.RuneSelf {
+			at = strings.Index(text, " "+name+" ")
+		} else {
+			at = strings.Index(text, name)
+		}
+		if at < 0 {
+			continue
+		}
+		if len(name) > len(best) || len(name) == len(best) && at < bestAt {
+			best, bestAt = name, at
+		}
+	}
+	if best == "" {
+		return nil, false
+	}
+	v := colorNames[best]
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+}
+
+// rowDefaultImage returns the picture the rules make is_def=1 (column J by
+// default), or the row's first picture.
+func rowDefaultImage(rules *imageRules, row []string, imagePath string, images []string, begin, end int) string {
+	i := begin
+	for seq := 1; begin+seq-1 <= end; seq++ {
+		if rules.isDef(row, seq, end-begin+1) == 1 {
+			i = begin + seq - 1
+			break
+		}
+	}
+	if i < 0 || i >= len(images) {
+		return ""
+	}
+	return filepath.Join(imagePath, images[i])
+}
@@ -0,0 +1,113 @@
+package logic
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+
+	"ahMakerdir/internal/manifest"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestParseColorName(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  uint32
+		ok    bool
+	}{
+		{"#8A1538", 0x8a1538, true},
+		{"8a1538", 0x8a1538, true},
+		{"Navy Stripe", 0x1f2a44, true},
+		{"深藍色", 0x1f2a44, true},
+		{"酒紅", 0x722f37, true},
+		{"Navy Blue", 0x1f2a44, true},
+		{"Blue/Navy", 0x1e4fa3, true}, // Same length, the first one written
+		{"Dark-Grey", 0x3c3c3c, true},
+		{"Red wood", 0xd32f2f, true},
+		{"Redwood", 0, false},
+		{"Goldenrod", 0, false},
+		{"淺灰藍", 0xcfcfcf, true},
+		{"beaded", 0, false},
+		{"", 0, false},
+	} {
+		c, ok := parseColorName(tc.value)
+		if ok != tc.ok {
+			t.Errorf("%q: ok = %v, want %v", tc.value, ok, tc.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		r, g, b, _ := c.RGBA()
+		if got := (r>>8)<<16 | (g>>8)<<8 | b>>8; got != tc.want {
+			t.Errorf("%q = %06x, want %06x", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestSplitGeneratesSwatch(t *testing.T) {
+	cfg := newWorkPath(t)
+	// ITEM2 has no column L; its only picture is green with a white border
+	img := imaging.New(100, 140, color.White)
+	img = imaging.Paste(img, imaging.New(60, 100, color.RGBA{0x20, 0xa0, 0x40, 0xff}), image.Pt(20, 20))
+	if err := imaging.Save(img, filepath.Join(cfg.WorkPath, "org", "a (3).jpg")); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SwatchGenerate = SwatchSample
+	cfg.SwatchSize = "30x20"
+
+	smallDirs, err := RunSplit(cfg, testLog(t))
+	if err != nil {
+		t.Fatalf("RunSplit: %v", err)
+	}
+	entries := readManifest(t, cfg.WorkPath)
+	if m := entries[smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_01.jpg")]; m.ColorPicFilename != "ITEM2_Color.png" {
+		t.Errorf("manifest[ITEM2_01.jpg] = %+v", m)
+	}
+	if m := entries[smallKey("Brand_Season", "ITEM2_Blue", "ITEM2_Color.png")]; m.Type != manifest.TypeColor || !m.ColorPicGenerated || m.SourceImage != "a (3).jpg" {
+		t.Errorf("manifest[ITEM2_Color.png] = %+v", m)
+	}
+	if m := entries[smallKey("Brand_Season", "ITEM1_Red", "ITEM1_Color.png")]; m.Type != manifest.TypeColor || m.ColorPicGenerated || m.SourceImage != "sw1.png" {
+		t.Errorf("manifest[ITEM1_Color.png] = %+v, column L swatch marked generated", m)
+	}
+
+	// Compression keeps the swatch size
+	if err := RunCompress(smallDirs, cfg, testLog(t)); err != nil {
+		t.Fatal(err)
+	}
+	swatch, err := imaging.Open(filepath.Join(cfg.WorkPath, "Brand_Season", "ITEM2_Blue", "SMALL", "ITEM2_Color.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := swatch.Bounds(); b.Dx() != 30 || b.Dy() != 20 {
+		t.Errorf("swatch is %dx%d, want 30x20", b.Dx(), b.Dy())
+	}
+	r, g, b, _ := swatch.At(5, 5).RGBA()
+	if r>>8 > 0x30 || g>>8 < 0x90 || b>>8 > 0x50 {
+		t.Errorf("swatch color = %02x%02x%02x, want about 20a040", r>>8, g>>8, b>>8)
+	}
+
+	// Column mode reports rows without a usable color
+	cfg.SwatchGenerate = SwatchColumn
+	cfg.SwatchColorColumn = "H"
+	swatches, err := newSwatchGenerator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := swatches.write(sheetRows[1], "", filepath.Join(t.TempDir(), "x.png")); err == nil {
+		t.Error("empty color column accepted")
+	}
+	dst := filepath.Join(t.TempDir(), "blue.png")
+	if err := (&swatchGenerator{mode: SwatchColumn, column: 6, width: 10, height: 10}).write(sheetRows[1], "", dst); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, region := range []string{"0.1,0.1", "0.5,0.5,0,0.2", "0.5,0.5,2,0.2"} {
+		cfg.SwatchRegion = region
+		if _, err := newSwatchGenerator(cfg); err == nil {
+			t.Errorf("SwatchRegion %q accepted", region)
+		}
+	}
+}
@@ -1,13 +1,12 @@
 package logic
 
 import (
+	"ahMakerdir/internal/api"
 	"ahMakerdir/internal/config"
-	"bytes"
-	"encoding/json"
+	"ahMakerdir/internal/manifest"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	pathpkg "path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -17,18 +16,12 @@ import (
 
 // RunUpload handles FTP upload of the SMALL directory and calls Laravel API
 func RunUpload(cfg config.Config, log func(string)) error {
-	log("Connecting to FTP...")
-	c, err := ftp.Dial(cfg.FtpHost+":"+cfg.FtpPort, ftp.DialWithTimeout(10*time.Second))
+	c, err := connectFtp(cfg, log)
 	if err != nil {
-		return fmt.Errorf("FTP dial error: %v", err)
+		return err
 	}
 	defer c.Quit()
 
-	if err := c.Login(cfg.FtpUser, cfg.FtpPassword); err != nil {
-		return fmt.Errorf("FTP login error: %v", err)
-	}
-	log("FTP Connected successfully.")
-
 	// Find all SMALL directories
 	var sourceDirs []string
 	log(fmt.Sprintf("Scanning for SMALL directories in %s...", cfg.WorkPath))
@@ -49,35 +42,49 @@ func RunUpload(cfg config.Config, log func(string)) error {
 
 	log(fmt.Sprintf("Found %d SMALL directories.", len(sourceDirs)))
 
-	var uploadedFiles []string
+	var uploadedFiles []uploadedFile
 	
-	// Target format: GoodsColor/YYYYMMDD/filename
-	uploadDate := time.Now().Format("20060102")
-	targetRoot := "GoodsColor"
-	remoteDir := fmt.Sprintf("%s/%s", targetRoot, uploadDate)
-
-	// Ensure remote directory exists once
-	if err := ensureFtpDir(c, remoteDir); err != nil {
-		log(fmt.Sprintf("Warning: Could not create remote dir %s: %v", remoteDir, err))
+	// Target format comes from RemotePathTemplate, default GoodsColor/YYYYMMDD/filename
+	pathTemplate := cfg.RemotePathTemplate
+	if pathTemplate == "" {
+		pathTemplate = DefaultRemotePathTemplate
+	}
+	if err := validateRemotePathTemplate(pathTemplate); err != nil {
+		return err
 	}
+	uploadDate := time.Now()
+	log(fmt.Sprintf("Remote path template: %s", pathTemplate))
 
-	// Load Manifest
-	manifestPath := filepath.Join(cfg.WorkPath, "manifest.json")
-	manifest := make(map[string]ImageMetadata)
-	if manifestData, err := os.ReadFile(manifestPath); err == nil {
-		json.Unmarshal(manifestData, &manifest)
-	} else {
-		log(fmt.Sprintf("Warning: Could not load manifest.json: %v. API data might be incomplete.", err))
+	collisionPolicy := cfg.RemoteCollisionPolicy
+	if collisionPolicy == "" {
+		collisionPolicy = CollisionOverwrite
+	}
+	if err := validateCollisionPolicy(collisionPolicy); err != nil {
+		return err
 	}
+	log(fmt.Sprintf("Remote collision policy: %s", collisionPolicy))
 
-	type ApiPayloadItem struct {
-		ExcelColD    string `json:"excel_col_d"`
-		FtpPath      string `json:"ftp_path"`
-		Sort         int    `json:"sort"`
-		IsDef        int    `json:"is_def"`
-		ColorPic     string `json:"color_pic,omitempty"`
+	// Ensure each remote directory exists once
+	createdDirs := make(map[string]bool)
+	remoteFiles := newRemoteIndex(c)
+	remoteByLocal := make(map[string]string) // local path -> final remote path
+	report := newRunReport(cfg.WorkPath)
+	payloadLocal := make(map[string]string)  // payload key -> local path
+	skippedCount := 0
+
+	// Load Manifest
+	runManifest, err := loadManifest(cfg.WorkPath)
+	if err != nil {
+		return fmt.Errorf("%v, nothing was uploaded", err)
 	}
-	apiPayload := make(map[string]ApiPayloadItem) // Changed to map as requested
+	if runManifest == nil {
+		log("Warning: No manifest.json in the work path. Files are uploaded but no API data is sent.")
+	} else if runManifest.Migrated {
+		log("manifest.json is in the old format, it is saved in the new format after the upload.")
+	}
+	entries := manifestEntries(runManifest)
+
+	apiPayload := make(api.Payload) // Changed to map as requested
 
 	for _, sourceDir := range sourceDirs {
 		// Walk through each source directory
@@ -89,66 +96,88 @@ func RunUpload(cfg config.Config, log func(string)) error {
 				return nil
 			}
 
-			// Get filename
+			// Get filename, files are found in the manifest by their path in the work path
 			filename := filepath.Base(path)
+			key := entryKey(cfg.WorkPath, path)
 			
 			// Remote path
-			remotePath := fmt.Sprintf("%s/%s", remoteDir, filename)
-			// Path to store in DB (with /image/ prefix)
-			storedPath := fmt.Sprintf("/image/%s", remotePath)
-			
-			// Open local file
-			f, err := os.Open(path)
-			if err != nil {
-				log(fmt.Sprintf("Failed to open local file %s: %v", d.Name(), err))
-				return nil
+			meta, ok := entries[key]
+			vars := localPathVars(cfg.WorkPath, path, meta, uploadDate)
+			plannedPath := renderRemotePath(pathTemplate, vars)
+
+			remoteDir := pathpkg.Dir(plannedPath)
+			if !createdDirs[remoteDir] {
+				if err := ensureFtpDir(c, remoteDir); err != nil {
+					log(fmt.Sprintf("Warning: Could not create remote dir %s: %v", remoteDir, err))
+				}
+				createdDirs[remoteDir] = true
 			}
-			defer f.Close()
 
-			// Upload file
-			//log(fmt.Sprintf("Uploading %s -> %s", filename, remotePath))
-			err = c.Stor(remotePath, f)
+			// Check for a file of the same name already on the server
+			remotePath, needUpload, err := resolveCollision(remoteFiles, collisionPolicy, path, plannedPath)
 			if err != nil {
-				log(fmt.Sprintf("Failed to upload %s: %v", filename, err))
+				log(fmt.Sprintf("Failed to check %s on FTP: %v", filename, err))
+				report.addFile(path, meta, vars, "", fileFailed)
 				return nil
 			}
+			if remotePath == "" {
+				log(fmt.Sprintf("Skipped %s: %s already exists on FTP", filename, plannedPath))
+				report.addFile(path, meta, vars, plannedPath, fileSkipped)
+				skippedCount++
+				return nil
+			}
+			if remotePath != plannedPath {
+				log(fmt.Sprintf("%s exists on FTP, using %s", pathpkg.Base(plannedPath), pathpkg.Base(remotePath)))
+			}
+			// Path to store in DB (with /image/ prefix)
+			storedPath := fmt.Sprintf("/image/%s", remotePath)
 
-			// Add to API payload
-			meta, ok := manifest[filename]
-			
-			// Don't error if not found, it might be the color pic itself being uploaded (which is not a key in manifest usually, or handle differently?)
-			// Or maybe the color pic IS in the manifest with its own key? 
-			// Wait, the manifest keyed by "newFilename" (Item_01.jpg). The Color Pic (Item_Color.jpg) is ALSO in the folder.
-			// When walk hits "Item_Color.jpg", it won't be in manifest keys (because split logic key is `newFilename`).
-			// So `ok` will be false. We should skip adding payload for the color pic file itself, 
-			// BUT we need to add the color pic PATH to the payloads of Item01, Item02...
-			
-			if !ok {
-				// Likely a file we copied there (like the Color Pic itself) but not one of the main items.
-				// Or an untracked file. Just skip creating a payload item for it.
+			// Upload file
+			//log(fmt.Sprintf("Uploading %s -> %s", filename, remotePath))
+			replaced := false
+			if needUpload {
+				replaced = remoteFiles.existedBefore(remotePath)
+				err = storFile(c, path, remotePath)
+				if err != nil {
+					log(fmt.Sprintf("Failed to upload %s: %v", filename, err))
+					report.addFile(path, meta, vars, "", fileFailed)
+					return nil
+				}
+				remoteFiles.add(remotePath)
+				report.addFile(path, meta, vars, remotePath, fileUploaded)
 			} else {
-				// Update manifest with FTP path
-				meta.FtpPath = storedPath
-				manifest[filename] = meta
-				
-				// Calculate Color Pic Remote Path if exists
-				colorPicPath := ""
-				if meta.ColorPicFilename != "" {
-					// The color pic was copied to the same remote dir
-					colorPicRemotePath := fmt.Sprintf("%s/%s", remoteDir, meta.ColorPicFilename)
-					colorPicPath = fmt.Sprintf("/image/%s", colorPicRemotePath)
+				log(fmt.Sprintf("%s already on FTP with identical content, not uploading again", filename))
+				report.addFile(path, meta, vars, remotePath, fileReused)
+			}
+			remoteByLocal[path] = remotePath
+
+			// Add to API payload. Color pics are not items of their own, their
+			// path is added to the item's images below; untracked files are
+			// only uploaded.
+			if ok && meta.Type != manifest.TypeColor {
+				if _, dup := apiPayload[filename]; dup {
+					// The API knows images by name only; older splits could repeat one across folders
+					log(fmt.Sprintf("Warning: %s is left out of the API call, another image is called %s too. Split again to give the files unique names.", key, filename))
+				} else {
+					// Color Pic Remote Path is filled in after the walk, once its final name is known
+					apiPayload[filename] = api.Item{
+						ExcelColD:    meta.ExcelColD,
+						FtpPath:      storedPath,
+						Sort:         meta.Sort,
+						IsDef:        meta.IsDef,
+					}
+					payloadLocal[filename] = path
 				}
+			}
 
-				apiPayload[filename] = ApiPayloadItem{
-					ExcelColD:    meta.ExcelColD,
-					FtpPath:      storedPath,
-					Sort:         meta.Sort,
-					IsDef:        meta.IsDef,
-					ColorPic:     colorPicPath,
-				}
+			if needUpload {
+				uploadedFiles = append(uploadedFiles, uploadedFile{
+					LocalPath:  path,
+					RemotePath: remotePath,
+					Filename:   filename,
+					Replaced:   replaced,
+				})
 			}
-			
-			uploadedFiles = append(uploadedFiles, filename)
 			return nil
 		})
 		if err != nil {
@@ -157,162 +186,180 @@ func RunUpload(cfg config.Config, log func(string)) error {
 	}
 
 	log(fmt.Sprintf("Uploaded %d files.", len(uploadedFiles)))
+	if skippedCount > 0 {
+		log(fmt.Sprintf("Skipped %d files that already exist on FTP.", skippedCount))
+	}
 
-	// Update manifest.json with FTP paths
-	if updatedManifest, err := json.MarshalIndent(manifest, "", "  "); err == nil {
-		if err := os.WriteFile(manifestPath, updatedManifest, 0644); err != nil {
+	// Point each item at its color pic, which lives in the same SMALL dir.
+	// A renamed color pic gets its new path, a skipped one is left out.
+	for filename, item := range apiPayload {
+		meta := entries[entryKey(cfg.WorkPath, payloadLocal[filename])]
+		if meta.ColorPicFilename == "" {
+			continue
+		}
+		colorLocal := filepath.Join(filepath.Dir(payloadLocal[filename]), meta.ColorPicFilename)
+		if color, ok := entries[entryKey(cfg.WorkPath, colorLocal)]; ok && color.Type == manifest.TypeColor && color.ExcelColD != meta.ExcelColD {
+			continue // Belongs to another item
+		}
+		if colorRemote, ok := remoteByLocal[colorLocal]; ok {
+			item.ColorPic = fmt.Sprintf("/image/%s", colorRemote)
+			apiPayload[filename] = item
+		}
+	}
+
+	// Verify uploads before the API learns about them
+	if cfg.VerifyUpload {
+		log("Verifying uploaded files...")
+		failed := verifyUploads(c, cfg, uploadedFiles, log)
+		// A broken copy is never sent to the API, so it is not left on the server either
+		if len(failed) > 0 {
+			rollbackUploads(c, failed, log)
+			broken := make(map[string]bool)
+			for _, file := range failed {
+				broken[file.RemotePath] = true
+			}
+			verified := uploadedFiles[:0]
+			for _, file := range uploadedFiles {
+				if !broken[file.RemotePath] {
+					verified = append(verified, file)
+				}
+			}
+			uploadedFiles = verified
+		}
+		for _, file := range failed {
+			report.setStatus(file.LocalPath, fileVerifyFailed)
+			if _, ok := apiPayload[file.Filename]; ok {
+				delete(apiPayload, file.Filename)
+				log(fmt.Sprintf(" - %s excluded from API call", file.Filename))
+			}
+			// A broken color pic is left out of its item's images
+			for filename, item := range apiPayload {
+				if item.ColorPic == "/image/"+file.RemotePath {
+					item.ColorPic = ""
+					apiPayload[filename] = item
+					log(fmt.Sprintf(" - color pic of %s left out of API call", filename))
+				}
+			}
+		}
+	}
+
+	// Update manifest.json with FTP paths and the upload state of every file
+	saveManifest := func() {
+		if runManifest == nil {
+			return
+		}
+		report.applyToManifest(runManifest)
+		if err := runManifest.Save(manifest.Path(cfg.WorkPath)); err != nil {
 			log(fmt.Sprintf("Warning: Failed to save updated manifest.json: %v", err))
 		} else {
 			log("Updated manifest.json with FTP paths.")
 		}
 	}
+	saveManifest()
+
+	// All-or-nothing mode: a failed or rejected API call removes every file
+	// uploaded in this run. The payload goes out as one chunk, so no accepted
+	// chunk can point to the removed files.
+	var rollback func(api.Payload)
+	if cfg.TransactionalUpload {
+		rollback = func(api.Payload) {
+			removed := rollbackUploads(c, uploadedFiles, log)
+			report.setStatusByRemote(removed, fileRolledBack)
+			saveManifest()
+		}
+	}
 
 	// Debug: Log payload regardless of API URL
 	//debugPayload, _ := json.MarshalIndent(apiPayload, "", "  ")
 	//log(fmt.Sprintf("Payload: %s", string(debugPayload)))
 
+	// Keep the exact payload on disk so it can be replayed if the API is down
+	if payloadFile, err := savePayload(cfg.WorkPath, apiPayload); err != nil {
+		log(fmt.Sprintf("Warning: Failed to save API payload: %v", err))
+	} else {
+		log(fmt.Sprintf("Saved API payload to: %s", payloadFile))
+	}
+
 	// Call Laravel API
 	if cfg.ApiUrl != "" {
-		log("Calling Laravel API...")
-		
-		type ApiResponse struct {
-			Status                  string   `json:"status"`
-			Message                 string   `json:"massage"` // Match user's PHP typo
-			NotFoundSNs             []string `json:"not_found_sns"`
-			SuccessGoodsColorPicIDs []int    `json:"success_goods_color_pic_ids"`
-			SuccessGoodsColorIDs    []int    `json:"success_goods_color_ids"`
-		}
-
-		respBody, err := callLaravelAPI(cfg.ApiUrl, cfg.ApiKey, apiPayload)
-		if err != nil {
-			// Try to parse error message from JSON body
-			var apiErrResp ApiResponse
-			if jsonErr := json.Unmarshal([]byte(respBody), &apiErrResp); jsonErr == nil && apiErrResp.Message != "" {
-				log("---------------------------------------------------")
-				log(fmt.Sprintf("API SERVER ERROR: %s", apiErrResp.Message))
-				log("---------------------------------------------------")
-			} else {
-				log(fmt.Sprintf("API Error: %v", err))
-			}
-		} else {
-			log("API notification sent successfully.")
-			
-			var apiResp ApiResponse
-			if jsonErr := json.Unmarshal([]byte(respBody), &apiResp); jsonErr == nil {
-				// Handle Not Found Warnings & Cleanup FTP
-				if len(apiResp.NotFoundSNs) > 0 {
-					log("---------------------------------------------------")
-					log(fmt.Sprintf("WARNING: %d Items Not Found in Database. Cleaning up FTP...", len(apiResp.NotFoundSNs)))
-					log("-------------以下料號上傳失敗-----------------")
-
-					// Create map for SN lookup
-					missingSNs := make(map[string]bool)
-					for _, sn := range apiResp.NotFoundSNs {
-						missingSNs[sn] = true
-						log(fmt.Sprintf(" - %s (Not found, deleting from FTP)", sn))
-					}
-
-					deletedCount := 0
-					deletedPaths := make(map[string]bool)
-
-					for _, item := range apiPayload {
-						if missingSNs[item.ExcelColD] {
-							// 1. Delete main image
-							if item.FtpPath != "" && !deletedPaths[item.FtpPath] {
-								ftpPath := strings.TrimPrefix(item.FtpPath, "/image/")
-								if err := c.Delete(ftpPath); err == nil {
-									deletedCount++
-								}
-								deletedPaths[item.FtpPath] = true
-							}
-
-							// 2. Delete color pic
-							if item.ColorPic != "" && !deletedPaths[item.ColorPic] {
-								ftpColorPath := strings.TrimPrefix(item.ColorPic, "/image/")
-								c.Delete(ftpColorPath) // Delete silently
-								deletedPaths[item.ColorPic] = true
-							}
-						}
-					}
-					if deletedCount > 0 {
-						log(fmt.Sprintf("Successfully removed %d invalid images from FTP.", deletedCount))
-					}
-					log("---------------------------------------------------")
-				}
+		outcome := submitPayload(c, cfg, apiPayload, rollback, log)
+		report.applyAPI(apiPayload, payloadLocal, outcome)
+		saveManifest() // Files removed for unknown item codes
+	} else {
+		log("Skipping API call (URL not set).")
+	}
 
-				// Prepare Results Directory
-				resultsDir := filepath.Join(cfg.WorkPath, "ApiResults")
-				if _, err := os.Stat(resultsDir); os.IsNotExist(err) {
-					os.MkdirAll(resultsDir, 0755)
-				}
-				timestamp := time.Now().Format("20060102_150405")
-
-				// Helper to save ID list
-				saveIDs := func(name string, ids []int) {
-					if len(ids) > 0 {
-						fileName := fmt.Sprintf("%s_%s.json", name, timestamp)
-						filePath := filepath.Join(resultsDir, fileName)
-						if data, err := json.MarshalIndent(ids, "", "  "); err == nil {
-							if err := os.WriteFile(filePath, data, 0644); err == nil {
-								log(fmt.Sprintf("Saved %d %s to: %s", len(ids), name, fileName))
-							} else {
-								log(fmt.Sprintf("Error saving %s: %v", name, err))
-							}
-						}
-					}
-				}
+	// One report per run tying rows, files and API results together
+	if reportPath, err := report.Save(filepath.Join(cfg.WorkPath, "ApiResults")); err != nil {
+		log(fmt.Sprintf("Warning: Failed to save run report: %v", err))
+	} else {
+		log(fmt.Sprintf("Saved run report to: %s", reportPath))
+	}
 
-				// Save GoodsColorPic IDs
-				saveIDs("success_goods_color_pic_ids", apiResp.SuccessGoodsColorPicIDs)
-				
-				// Save GoodsColor IDs
-				saveIDs("success_goods_color_ids", apiResp.SuccessGoodsColorIDs)
-			}
+	if cfg.ExcelWriteBack {
+		if xlsxPath, err := writeBackExcel(cfg, manifestEntries(runManifest), report, filepath.Join(cfg.WorkPath, "ApiResults")); err != nil {
+			log(fmt.Sprintf("Warning: Failed to write results to Excel copy: %v", err))
+		} else {
+			log(fmt.Sprintf("Saved Excel with results to: %s", xlsxPath))
 		}
-	} else {
-		log("Skipping API call (URL not set).")
 	}
 
 	return nil
 }
 
-
-
-func callLaravelAPI(url, apiKey string, payload interface{}) (string, error) { // Updated signature
-	jsonBytes, err := json.Marshal(payload)
-	if err != nil {
-		return "", err
+// rollbackUploads deletes the files uploaded in this run from the FTP server.
+// Files that replaced an existing remote file are kept, since deleting them
+// would also remove the image that was live before the run.
+// It returns the set of remote paths that were removed.
+func rollbackUploads(c *ftp.ServerConn, files []uploadedFile, log func(string)) map[string]bool {
+	log("---------------------------------------------------")
+	log(fmt.Sprintf("ROLLBACK: removing %d files uploaded in this run...", len(files)))
+
+	removed := make(map[string]bool)
+	var failed, kept []string
+	for _, file := range files {
+		if file.Replaced {
+			kept = append(kept, file.RemotePath)
+			continue
+		}
+		if removed[file.RemotePath] {
+			continue // Written twice in this run
+		}
+		if err := c.Delete(file.RemotePath); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", file.RemotePath, err))
+			continue
+		}
+		removed[file.RemotePath] = true
+		log(fmt.Sprintf(" - removed %s", file.RemotePath))
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
-	if err != nil {
-		return "", err
+	log(fmt.Sprintf("ROLLBACK: removed %d, kept %d overwritten, %d failed.", len(removed), len(kept), len(failed)))
+	for _, p := range kept {
+		log(fmt.Sprintf(" ! kept %s (it replaced an existing file)", p))
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	
-	if apiKey != "" {
-		req.Header.Set("key", apiKey)
+	for _, p := range failed {
+		log(fmt.Sprintf(" ! could not remove %s", p))
 	}
+	log("---------------------------------------------------")
+	return removed
+}
 
-	client := &http.Client{Timeout: 30 * time.Second} // Increased timeout for dd() which might be slow or large
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+
+// connectFtp dials and logs in to the configured FTP server.
+func connectFtp(cfg config.Config, log func(string)) (*ftp.ServerConn, error) {
+	log("Connecting to FTP...")
+	c, err := ftp.Dial(cfg.FtpHost+":"+cfg.FtpPort, ftp.DialWithTimeout(10*time.Second))
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("FTP dial error: %v", err)
 	}
-	bodyString := string(bodyBytes)
 
-	if resp.StatusCode >= 400 {
-		return bodyString, fmt.Errorf("API returned status: %s. Body: %s", resp.Status, bodyString)
+	if err := c.Login(cfg.FtpUser, cfg.FtpPassword); err != nil {
+		c.Quit()
+		return nil, fmt.Errorf("FTP login error: %v", err)
 	}
-
-	return bodyString, nil
+	log("FTP Connected successfully.")
+	return c, nil
 }
 
 // ensureFtpDir checks if simple directory structure exists, creating it if not.
@@ -0,0 +1,245 @@
+package logic
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"ahMakerdir/internal/config"
+)
+
+// Validation severities
+const (
+	SeverityError   = "ERROR"   // Split refuses to run
+	SeverityWarning = "WARNING" // Split runs, the row may be incomplete
+)
+
+// ValidationIssue is one problem found in the sheet.
+type ValidationIssue struct {
+	Sheet    string `json:"sheet,omitempty"`  // Set when several sheets are processed
+	Row      int    `json:"row"`              // 1-based Excel row
+	Column   string `json:"column,omitempty"` // Excel column letter, empty for the whole row
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Severity, i.cell(), i.Message)
+}
+
+// cell returns the location in Excel notation, e.g. D3 or Brand A!D3.
+func (i ValidationIssue) cell() string {
+	cell := fmt.Sprintf("Row %d", i.Row)
+	if i.Column != "" {
+		cell = fmt.Sprintf("%s%d", i.Column, i.Row)
+	}
+	if i.Row == 0 {
+		cell = "Sheet"
+	}
+	if i.Sheet != "" {
+		cell = i.Sheet + "!" + cell
+	}
+	return cell
+}
+
+// ValidateExcel checks the sheet RunSplit would process without creating anything.
+func ValidateExcel(cfg config.Config, log func(string)) ([]ValidationIssue, error) {
+	dirPath := strings.TrimSpace(cfg.WorkPath)
+	excelFile, sheets, err := loadSheets(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	imageCounts := make([]int, len(sheets))
+	for i, sheet := range sheets {
+		log(fmt.Sprintf("Validating %s, sheet %s (%d rows)...", excelFile, sheet.Name, len(sheet.Rows)))
+		imageCounts[i] = -1 // Unknown, skip the image count check
+		if images, err := scanImages(filepath.Join(dirPath, sheet.PictureDir)); err == nil {
+			imageCounts[i] = len(images)
+		} else {
+			log(fmt.Sprintf("Warning: %v", err))
+		}
+	}
+
+	var issues []ValidationIssue
+	sources := newSourceIndex(cfg, log)
+	defer sources.save()
+	sizeTables, err := newSizeTableResolver(cfg, sources)
+	if err != nil {
+		issues = append(issues, ValidationIssue{Severity: SeverityError, Message: err.Error()})
+	}
+	if _, err := parseFilenameTemplate(cfg.FilenameTemplate); err != nil {
+		issues = append(issues, ValidationIssue{Severity: SeverityError, Message: err.Error()})
+	}
+	rules, err := newImageRules(cfg)
+	if err != nil {
+		issues = append(issues, ValidationIssue{Severity: SeverityError, Message: err.Error()})
+	}
+	issues = append(issues, validateSheets(cfg, sheets, imageCounts, sizeTables, sources.lookup(cfg.ColorPicPath), rules)...)
+	errorCount := logValidation(issues, log)
+	if rules != nil {
+		logImagePlan(rules, sheets, log) // What Split would do with the default images
+	}
+	log(fmt.Sprintf("Validation finished: %d errors, %d warnings.", errorCount, len(issues)-errorCount))
+	return issues, nil
+}
+
+// CountErrors returns the number of issues that block Split.
+func CountErrors(issues []ValidationIssue) int {
+	errorCount := 0
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			errorCount++
+		}
+	}
+	return errorCount
+}
+
+// logValidation writes the issues to log and returns the number of errors.
+func logValidation(issues []ValidationIssue, log func(string)) int {
+	for _, issue := range issues {
+		log(issue.String())
+	}
+	return CountErrors(issues)
+}
+
+// Characters Windows and the FTP server do not accept in folder and file names
+const illegalNameChars = `\/:*?"<>|`
+
+// validateSheets checks every sheet; an item code may only be used again
+// across all of them with another color.
+func validateSheets(cfg config.Config, sheets []sheetInput, imageCounts []int, sizeTables *sizeTableResolver, colorPics *fileLookup, rules *imageRules) []ValidationIssue {
+	var issues []ValidationIssue
+	filenames, _ := parseFilenameTemplate(cfg.FilenameTemplate) // A bad template is reported by the caller
+	names := newItemNamer(filenames)
+	for i, sheet := range sheets {
+		issues = append(issues, validateRows(cfg, sheet.Label, sheet.Rows, imageCounts[i], names, sizeTables, colorPics, rules)...)
+	}
+	return issues
+}
+
+// validateRows checks the rows as RunSplit reads them: A/B build the brand
+// folder, C the size table, D the item code, G the color, I the image count,
+// the default image columns of rules (J/K by default) and L the color pic.
+// imageCount < 0 skips the check against the picture directory. names holds
+// the item codes of the rows checked before. A nil sizeTables skips the size
+// table lookup, nil rules the default image columns.
+func validateRows(cfg config.Config, sheet string, rows [][]string, imageCount int, names *itemNamer, sizeTables *sizeTableResolver, colorPics *fileLookup, rules *imageRules) []ValidationIssue {
+	var issues []ValidationIssue
+	add := func(row int, col, severity, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{Sheet: sheet, Row: row, Column: col, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	total := 0
+	for index, row := range rows {
+		r := index + 1
+		if isBlankRow(row) {
+			continue
+		}
+		if len(row) < 9 {
+			add(r, "", SeverityWarning, "only %d columns, needs at least A to I; row is skipped", len(row))
+			continue
+		}
+
+		step, err := strconv.Atoi(row[8])
+		if err != nil {
+			if index == 0 {
+				add(r, "I", SeverityWarning, "image count %q is not a number; treated as a header row and skipped", row[8])
+			} else {
+				add(r, "I", SeverityError, "image count %q is not a number", row[8])
+			}
+			continue
+		}
+		if step < 1 {
+			add(r, "I", SeverityError, "image count must be at least 1, got %d", step)
+			continue
+		}
+		total += step
+
+		// Rows sharing a code get their own file names, but not their own folder
+		code := strings.TrimSpace(row[3])
+		color := strings.ReplaceAll(row[6], "/", "")
+		cell := ValidationIssue{Sheet: sheet, Row: r, Column: "D"}.cell()
+		if code == "" {
+			add(r, "D", SeverityError, "item code is blank")
+		} else if first, ok := names.firstInFolder(row[3], color); ok {
+			add(r, "D", SeverityError, "item code %s with color %q already used in %s", code, color, first)
+		} else if first, ok := names.firstUse(row[3]); ok {
+			add(r, "D", SeverityWarning, "item code %s already used in %s; files of this row are named %s_*", code, first, names.name(row[3], color, cell, step))
+		} else if name := names.name(row[3], color, cell, step); name != row[3] {
+			add(r, "D", SeverityWarning, "file names of item code %s clash with those of an earlier row; files of this row are named %s_*", code, name)
+		}
+
+		for _, col := range []struct {
+			letter string
+			index  int
+			name   string
+		}{{"A", 0, "folder name"}, {"B", 1, "folder name"}, {"D", 3, "item code"}, {"G", 6, "color"}} {
+			value := row[col.index]
+			if col.index == 6 {
+				value = strings.ReplaceAll(value, "/", "") // RunSplit drops slashes in colors
+			}
+			if bad := illegalChars(value); bad != "" {
+				add(r, col.letter, SeverityError, "%s %q contains characters not allowed in file names: %s", col.name, value, bad)
+			} else if value != strings.TrimRight(value, ". ") {
+				add(r, col.letter, SeverityError, "%s %q ends with a dot or space", col.name, value)
+			}
+		}
+
+		if rules != nil {
+			for _, col := range rules.columns() {
+				value := strings.TrimSpace(cellValue(row, col.column))
+				if value == "" {
+					continue
+				}
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					add(r, col.letter, SeverityError, "default image %q is not a number", value)
+				} else if n < 1 || n > step {
+					add(r, col.letter, SeverityError, "default image %d is outside the %d images of the row", n, step)
+				}
+			}
+			if rules.sortMode == DuplicateSortOffset && rules.sortOffset < step && len(rules.copy) > 0 {
+				add(r, "I", SeverityWarning, "copies sorted at offset %d can share sort numbers with the %d images of the row", rules.sortOffset, step)
+			}
+		}
+
+		if strings.TrimSpace(styleNo(row)) == "" {
+			add(r, "C", SeverityWarning, "style number is blank, no size table will be copied")
+		} else if sizeTables != nil && cfg.SizeTablePath != "" {
+			if _, ok := sizeTables.resolve(row); !ok {
+				add(r, "C", SeverityWarning, "no size table found for %s (rules: %s)%s", row[2], sizeTables.ruleNames(), sizeTables.suggestion(row))
+			}
+		}
+
+		if name := strings.TrimSpace(cellValue(row, 11)); name != "" && cfg.ColorPicPath != "" && colorPics.find(name, colorPicExtensions) == "" {
+			add(r, "L", SeverityWarning, "color pic %s not found%s", name, colorPics.suggestion(name))
+		}
+	}
+
+	if imageCount >= 0 && total != imageCount {
+		add(0, "", SeverityWarning, "rows need %d images but the picture directory has %d", total, imageCount)
+	}
+	return issues
+}
+
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// illegalChars returns the distinct illegal file name characters in s.
+func illegalChars(s string) string {
+	var bad []rune
+	for _, ch := range s {
+		if (strings.ContainsRune(illegalNameChars, ch) || ch < 0x20) && !strings.ContainsRune(string(bad), ch) {
+			bad = append(bad, ch)
+		}
+	}
+	return string(bad)
+}
@@ -0,0 +1,72 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestValidateRows(t *testing.T) {
+	cfg := newWorkPath(t)
+	rows := [][]string{
+		{"Brand", "Season", "Style", "Item", "", "", "Color", "", "Count"},
+		{"Brand", "Season", "S100-1", "ITEM1", "", "", "Red/Blue", "", "2", "1", "3", "sw1"},
+		{"Brand", "Season", "S100-1", "", "", "", "Red", "", "1"},
+		{"Brand", "Season", "S100-1", "ITEM1", "", "", "Red", "", "x"},
+		{"Bra:nd", "Season.", "S300-1", "ITEM1", "", "", "Red/Blue", "", "1", "", "", "nope"},
+		{"Brand", "Season", "S100-1", "ITEM1", "", "", "Blue", "", "1"},
+		{},
+		{"short"},
+	}
+
+	got := make(map[string]string)
+	for _, issue := range validateRows(cfg, "", rows, 3, newItemNamer(nil), &sizeTableResolver{dir: cfg.SizeTablePath, rules: []sizeTableRule{{kind: SizeTableRuleStyle}}, exts: []string{".jpg"}}, newFileLookup(cfg.ColorPicPath), testImageRules(t, cfg)) {
+		got[strings.SplitN(issue.String(), ":", 2)[0]] = issue.Message
+	}
+	want := []string{
+		"WARNING I1", // Header row
+		"ERROR K2",   // Default image 3 of 2
+		"ERROR D3",   // Blank item code
+		"ERROR I4",   // Non-numeric count
+		"ERROR A5",   // Illegal character
+		"ERROR B5",   // Trailing dot
+		"ERROR D5",   // Same item and color as row 2
+		"WARNING D6", // Same item, other color
+		"WARNING C5", // Missing size table
+		"WARNING L5", // Missing color pic
+		"WARNING Row 8",
+		"WARNING Sheet", // 5 images needed, 3 found
+	}
+	for _, key := range want {
+		if _, ok := got[key]; !ok {
+			t.Errorf("missing issue %s", key)
+		}
+		delete(got, key)
+	}
+	for key, msg := range got {
+		t.Errorf("unexpected issue %s: %s", key, msg)
+	}
+}
+
+func TestSplitBlocksOnValidationErrors(t *testing.T) {
+	cfg := newWorkPath(t)
+	xlsx, err := excelize.OpenFile(filepath.Join(cfg.WorkPath, "list.xlsx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xlsx.SetCellValue("Sheet1", "D2", "")
+	if err := xlsx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	xlsx.Close()
+
+	if _, err := RunSplit(cfg, testLog(t)); err == nil || !strings.Contains(err.Error(), "validation failed") {
+		t.Fatalf("RunSplit error = %v, want validation failure", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.WorkPath, "Brand_Season")); !os.IsNotExist(err) {
+		t.Error("folders were created despite validation errors")
+	}
+}
@@ -0,0 +1,286 @@
+package logic
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"ahMakerdir/internal/config"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// uploadedFile records a local file and where it was stored on the FTP server.
+type uploadedFile struct {
+	LocalPath  string
+	RemotePath string
+	Filename   string
+	Replaced   bool // A file that was on the server before the run was overwritten
+}
+
+// storFile uploads a local file to the given remote path.
+func storFile(c *ftp.ServerConn, localPath, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Stor(remotePath, f)
+}
+
+// verifyUploads compares every uploaded file against its local source and
+// re-uploads mismatches up to cfg.VerifyRetries times, 0 only checks them.
+// It returns the files that still failed verification.
+func verifyUploads(c *ftp.ServerConn, cfg config.Config, files []uploadedFile, log func(string)) []uploadedFile {
+	retries := cfg.VerifyRetries
+
+	// The hash commands are not exposed by the FTP client, so they go over a
+	// second control connection. Servers without them fall back to size only.
+	h, err := dialHashConn(cfg)
+	if err != nil {
+		log(fmt.Sprintf("Verify: hash check unavailable (%v), comparing sizes only.", err))
+	} else {
+		defer h.Close()
+		log(fmt.Sprintf("Verify: using %s for checksum comparison.", h.method))
+	}
+
+	var failed []uploadedFile
+	mismatches := 0
+	for _, file := range files {
+		err := verifyRemoteFile(c, h, file)
+		for attempt := 1; err != nil && attempt <= retries; attempt++ {
+			mismatches++
+			log(fmt.Sprintf("Verify: %s mismatch (%v), re-uploading (attempt %d/%d)", file.Filename, err, attempt, retries))
+			if storErr := storFile(c, file.LocalPath, file.RemotePath); storErr != nil {
+				err = storErr
+				continue
+			}
+			err = verifyRemoteFile(c, h, file)
+		}
+		if err != nil {
+			log(fmt.Sprintf("Verify: %s failed verification: %v", file.Filename, err))
+			failed = append(failed, file)
+		}
+	}
+
+	log(fmt.Sprintf("Verify: checked %d files, %d re-uploads, %d failed.", len(files), mismatches, len(failed)))
+	return failed
+}
+
+// verifyRemoteFile checks the remote size and, when h is not nil, the remote checksum.
+func verifyRemoteFile(c *ftp.ServerConn, h *hashConn, file uploadedFile) error {
+	info, err := os.Stat(file.LocalPath)
+	if err != nil {
+		return err
+	}
+
+	remoteSize, err := c.FileSize(file.RemotePath)
+	if err != nil {
+		// SIZE is optional, MLST carries the size as well
+		entry, mlstErr := c.GetEntry(file.RemotePath)
+		if mlstErr != nil {
+			return fmt.Errorf("cannot read remote size: %v", err)
+		}
+		remoteSize = int64(entry.Size)
+	}
+	if remoteSize != info.Size() {
+		return fmt.Errorf("size %d, expected %d", remoteSize, info.Size())
+	}
+
+	if h == nil {
+		return nil
+	}
+	remoteSum, err := h.Sum(file.RemotePath)
+	if err != nil {
+		return fmt.Errorf("remote checksum: %v", err)
+	}
+	localSum, err := h.localSum(file.LocalPath)
+	if err != nil {
+		return err
+	}
+	// Some servers drop leading zeros from CRC values
+	if !strings.EqualFold(strings.TrimLeft(remoteSum, "0"), strings.TrimLeft(localSum, "0")) {
+		return fmt.Errorf("checksum %s, expected %s", remoteSum, localSum)
+	}
+	return nil
+}
+
+// hashConn is a raw FTP control connection used for the HASH, XMD5 and XCRC commands.
+type hashConn struct {
+	conn   *textproto.Conn
+	method string // HASH algorithm name, XMD5 or XCRC
+}
+
+// dialHashConn logs in on a separate control connection and picks the best
+// checksum command advertised by the server.
+func dialHashConn(cfg config.Config) (*hashConn, error) {
+	nc, err := net.DialTimeout("tcp", cfg.FtpHost+":"+cfg.FtpPort, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	h := &hashConn{conn: textproto.NewConn(nc)}
+
+	if _, _, err := h.conn.ReadResponse(ftp.StatusReady); err != nil {
+		h.conn.Close()
+		return nil, err
+	}
+	code, _, err := h.cmd("USER %s", cfg.FtpUser)
+	if err == nil && code == ftp.StatusUserOK {
+		code, _, err = h.cmd("PASS %s", cfg.FtpPassword)
+	}
+	if err == nil && code != ftp.StatusLoggedIn {
+		err = fmt.Errorf("login refused (%d)", code)
+	}
+	if err != nil {
+		h.conn.Close()
+		return nil, err
+	}
+
+	code, msg, err := h.cmd("FEAT")
+	if err != nil || code != ftp.StatusSystem {
+		h.Close()
+		return nil, fmt.Errorf("server does not list features")
+	}
+	h.method = pickHashMethod(msg)
+	if h.method == "" {
+		h.Close()
+		return nil, fmt.Errorf("server has no HASH/XMD5/XCRC command")
+	}
+	return h, nil
+}
+
+// pickHashMethod chooses from a FEAT reply, preferring HASH over XMD5 over XCRC.
+// For HASH the algorithm currently selected on the server (marked with *) is
+// used when we can compute it locally.
+func pickHashMethod(feat string) string {
+	var hashAlgos string
+	features := make(map[string]bool)
+	for _, line := range strings.Split(feat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(line, " ") {
+			continue
+		}
+		name := strings.ToUpper(fields[0])
+		features[name] = true
+		if name == "HASH" && len(fields) > 1 {
+			hashAlgos = fields[1]
+		}
+	}
+
+	if features["HASH"] {
+		for _, algo := range strings.Split(hashAlgos, ";") {
+			if strings.HasSuffix(algo, "*") {
+				algo = strings.ToUpper(strings.TrimSuffix(algo, "*"))
+				if newHash(algo) != nil {
+					return algo
+				}
+			}
+		}
+	}
+	if features["XMD5"] {
+		return "XMD5"
+	}
+	if features["XCRC"] {
+		return "XCRC"
+	}
+	return ""
+}
+
+// Sum asks the server for the checksum of a remote file.
+func (h *hashConn) Sum(remotePath string) (string, error) {
+	verb := h.method
+	if verb != "XMD5" && verb != "XCRC" {
+		verb = "HASH"
+	}
+	code, msg, err := h.cmd("%s %s", verb, remotePath)
+	if err != nil {
+		return "", err
+	}
+	if code/100 != 2 {
+		return "", fmt.Errorf("%s refused: %d %s", verb, code, msg)
+	}
+
+	// HASH replies "SHA-256 0-1234 <hex> name", XMD5/XCRC reply with the bare
+	// checksum, optionally surrounded by text. Take the first hex token.
+	fields := strings.Fields(msg)
+	if verb == "HASH" && len(fields) >= 3 {
+		fields = fields[2:3]
+	}
+	for _, field := range fields {
+		if isHex(field) {
+			return field, nil
+		}
+	}
+	return "", fmt.Errorf("unexpected %s reply: %s", verb, msg)
+}
+
+// localSum computes the checksum of a local file with the negotiated method.
+func (h *hashConn) localSum(localPath string) (string, error) {
+	algo := h.method
+	switch algo {
+	case "XMD5":
+		algo = "MD5"
+	case "XCRC":
+		algo = "CRC32"
+	}
+	hasher := newHash(algo)
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func (h *hashConn) cmd(format string, args ...interface{}) (int, string, error) {
+	if _, err := h.conn.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return h.conn.ReadResponse(0)
+}
+
+// Close ends the session.
+func (h *hashConn) Close() error {
+	h.cmd("QUIT")
+	return h.conn.Close()
+}
+
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case "SHA-256":
+		return sha256.New()
+	case "SHA-1":
+		return sha1.New()
+	case "MD5":
+		return md5.New()
+	case "CRC32":
+		return crc32.NewIEEE()
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package logic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"ahMakerdir/internal/config"
+	"ahMakerdir/internal/manifest"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Status columns appended to the workbook copy, in order
+var writeBackColumns = []string{"Images", "Color Pic", "Size Table", "Default Image FTP Path", "API Result"}
+
+// writeBackExcel saves a copy of the source workbook to dir with status columns
+// for every row, so problems can be filtered for in Excel. The original file is
+// never modified.
+func writeBackExcel(cfg config.Config, entries map[string]ImageMetadata, report *RunReport, dir string) (string, error) {
+	excelFile, xlsx, err := openWorkbook(cfg)
+	if err != nil {
+		return "", err
+	}
+	defer xlsx.Close()
+
+	specs := parseSheets(cfg)
+	// Names rows across sheets as Split did, which stopped on a bad template
+	filenames, _ := parseFilenameTemplate(cfg.FilenameTemplate)
+	names := newItemNamer(filenames)
+	for _, spec := range specs {
+		sheetName, err := resolveSheet(xlsx, spec.Sheet)
+		if err != nil {
+			return "", err
+		}
+		label := "" // Matches ImageMetadata.Sheet
+		if len(specs) > 1 {
+			label = sheetName
+		}
+		if err := writeBackSheet(xlsx, sheetName, label, cfg, entries, report, names); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	// Macro workbooks keep their extension, CSV/TSV input becomes a new workbook
+	ext := strings.ToLower(filepath.Ext(excelFile))
+	if ext != ".xlsm" {
+		ext = ".xlsx"
+	}
+	name := fmt.Sprintf("%s_result_%s%s", strings.TrimSuffix(excelFile, filepath.Ext(excelFile)), report.RunID, ext)
+	outPath := filepath.Join(dir, name)
+	if err := xlsx.SaveAs(outPath); err != nil {
+		return "", fmt.Errorf("failed to save workbook copy: %w", err)
+	}
+	return outPath, nil
+}
+
+// writeBackSheet adds the status columns to one sheet.
+func writeBackSheet(xlsx *excelize.File, sheetName, label string, cfg config.Config, entries map[string]ImageMetadata, report *RunReport, names *itemNamer) error {
+	rows, err := xlsx.GetRows(sheetName)
+	if err != nil {
+		return fmt.Errorf("failed to get rows: %w", err)
+	}
+
+	// Status columns go after the widest row, never before column L (color pic)
+	width := 12
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	// Sheets usually start with data; give the copy a header row so Excel can filter it
+	offset := 0
+	if len(rows) > 0 && isDataRow(rows[0]) {
+		if err := xlsx.InsertRows(sheetName, 1, 1); err != nil {
+			return fmt.Errorf("failed to insert header row: %w", err)
+		}
+		offset = 1
+	}
+	for i, title := range writeBackColumns {
+		cell, _ := excelize.CoordinatesToCellName(width+1+i, 1)
+		xlsx.SetCellValue(sheetName, cell, title)
+	}
+
+	for index, row := range rows {
+		if !isDataRow(row) {
+			continue
+		}
+		step, _ := strconv.Atoi(row[8])
+		name := names.name(row[3], strings.ReplaceAll(row[6], "/", ""), "", step)
+		for i, value := range writeBackRow(cfg, entries, report, label, index+1, name, row) {
+			cell, _ := excelize.CoordinatesToCellName(width+1+i, index+1+offset)
+			xlsx.SetCellValue(sheetName, cell, value)
+		}
+	}
+
+	last, _ := excelize.CoordinatesToCellName(width+len(writeBackColumns), len(rows)+offset)
+	if err := xlsx.AutoFilter(sheetName, "A1:"+last, nil); err != nil {
+		return fmt.Errorf("failed to add filter: %w", err)
+	}
+	return nil
+}
+
+// isDataRow reports whether RunSplit processes the row.
+func isDataRow(row []string) bool {
+	if len(row) < 9 {
+		return false
+	}
+	_, err := strconv.Atoi(row[8])
+	return err == nil
+}
+
+// writeBackRow returns the status values of one Excel row (1-based excelRow)
+// whose files Split named name.
+func writeBackRow(cfg config.Config, entries map[string]ImageMetadata, report *RunReport, sheet string, excelRow int, name string, row []string) []interface{} {
+	code := row[3]
+	step, _ := strconv.Atoi(row[8])
+
+	images := 0
+	colorPicFound, colorPicGenerated := false, false
+	defaultPath := ""
+	for _, meta := range entries {
+		// Manifests from before excel_row was recorded only have the item code
+		if meta.Sheet != sheet || meta.ExcelRow != excelRow && (meta.ExcelRow != 0 || meta.ExcelColD != code) {
+			continue
+		}
+		if meta.Type == manifest.TypeColor {
+			colorPicFound = true
+			colorPicGenerated = meta.ColorPicGenerated
+			continue
+		}
+		if meta.Sort <= step { // Duplicates of the default images sort after the row's images
+			images++
+		}
+		if meta.IsDef == 1 {
+			defaultPath = meta.FtpPath
+		}
+	}
+
+	colorPic := ""
+	if colorPicGenerated {
+		colorPic = "generated"
+	} else if len(row) > 11 && strings.TrimSpace(row[11]) != "" {
+		colorPic = "missing"
+		if colorPicFound {
+			colorPic = "found"
+		}
+	}
+
+	sizeTable := "missing"
+	if _, err := os.Stat(filepath.Join(cfg.WorkPath, row[0]+"_"+row[1], "OUT", name+"_"+styleNo(row)+".jpg")); err == nil {
+		sizeTable = "found"
+	}
+
+	apiResult := "not_sent"
+	if it := report.rowItem(sheet, excelRow, code); it != nil {
+		apiResult = it.ApiResult
+	}
+	if apiResult == "not_found" {
+		defaultPath = "" // Removed from FTP again
+	}
+
+	return []interface{}{images, colorPic, sizeTable, defaultPath, apiResult}
+}
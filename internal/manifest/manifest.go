@@ -0,0 +1,401 @@
+// Package manifest is the manifest.json written by Split and read by
+// Compress and Upload: the items of a run, their files and renditions, and
+// the upload state of every file.
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileName is the manifest file in the work path.
+const FileName = "manifest.json"
+
+// Version of the format written by Save. Version 1 is the flat map keyed by
+// file name written before the format was versioned; Load migrates it.
+const Version = 2
+
+// File types
+const (
+	TypeImage = "image"
+	TypeColor = "color" // <ItemCode>_Color swatch, shared by the item's images
+)
+
+// Renditions of a file, named after the folders Split copies it to
+const (
+	RenditionBig   = "BIG"
+	RenditionSmall = "SMALL"
+	RenditionOut   = "OUT"
+)
+
+// Manifest describes one Split run.
+type Manifest struct {
+	Version   int       `json:"version"`
+	RunID     string    `json:"run_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Migrated  bool      `json:"migrated,omitempty"` // Read from a version 1 file
+	Items     []*Item   `json:"items"`
+}
+
+// Item is one Excel row.
+type Item struct {
+	Code     string   `json:"code"`           // Column D
+	Name     string   `json:"name,omitempty"` // Base of the file names, Code unless another row used it first
+	Sheet    string   `json:"sheet,omitempty"`
+	ExcelRow int      `json:"excel_row,omitempty"`
+	Folder   string   `json:"folder,omitempty"` // Columns A_B
+	Color    string   `json:"color,omitempty"`  // Column G
+	Warnings []string `json:"warnings,omitempty"`
+	Files    []*File  `json:"files"`
+}
+
+// File is an image or the color pic of an item.
+type File struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Sort        int         `json:"sort,omitempty"`
+	IsDef       int         `json:"is_def"`
+	ColorPic    string      `json:"color_pic,omitempty"` // Name of the item's color file
+	Generated   bool        `json:"generated,omitempty"` // Color pic made by SwatchGenerate
+	SourceImage string      `json:"source_image,omitempty"`
+	Renditions  []Rendition `json:"renditions,omitempty"`
+	Upload      *Upload     `json:"upload,omitempty"`
+}
+
+// Rendition is one copy of a file in the work path.
+type Rendition struct {
+	Name   string `json:"name"` // RenditionBig, RenditionSmall or RenditionOut
+	Path   string `json:"path"` // Relative to the work path, with forward slashes
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Upload is the upload state of the SMALL rendition.
+type Upload struct {
+	FtpPath    string    `json:"ftp_path,omitempty"` // As stored in the database, /image/...
+	Status     string    `json:"status"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// New returns an empty manifest for a run.
+func New(runID string) *Manifest {
+	now := time.Now()
+	return &Manifest{Version: Version, RunID: runID, CreatedAt: now, UpdatedAt: now}
+}
+
+// Path returns the manifest path in workPath.
+func Path(workPath string) string {
+	return filepath.Join(strings.TrimSpace(workPath), FileName)
+}
+
+// Load reads and validates a manifest. Version 1 files are migrated.
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Parse decodes and validates a manifest. Version 1 data is migrated.
+func Parse(data []byte) (*Manifest, error) {
+	var probe struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("not a manifest: %v", err)
+	}
+
+	var m *Manifest
+	if probe.Version == nil {
+		var flat map[string]legacyEntry
+		if err := json.Unmarshal(data, &flat); err != nil {
+			return nil, fmt.Errorf("not a version 1 manifest: %v", err)
+		}
+		m = migrate(flat)
+	} else {
+		if *probe.Version != Version {
+			return nil, fmt.Errorf("manifest version %d is not supported, expected %d", *probe.Version, Version)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		m = &Manifest{}
+		if err := dec.Decode(m); err != nil {
+			return nil, fmt.Errorf("invalid manifest: %v", err)
+		}
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Save validates m and writes it to path, replacing the old file only once
+// the new one is complete.
+func (m *Manifest) Save(path string) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	m.Version = Version
+	m.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Validate checks the structure and returns every problem found.
+func (m *Manifest) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if m.RunID == "" {
+		fail("run_id is missing")
+	}
+
+	keys := make(map[string]string) // File key -> item code
+	for i, it := range m.Items {
+		where := fmt.Sprintf("item %d", i+1)
+		if it.Code == "" {
+			fail("%s: code is missing", where)
+		} else {
+			where = fmt.Sprintf("item %s", it.Code)
+		}
+		if it.ExcelRow < 0 {
+			fail("%s: excel_row %d is negative", where, it.ExcelRow)
+		}
+
+		colors := make(map[string]bool)
+		for _, f := range it.Files {
+			if f.Type == TypeColor {
+				colors[f.Name] = true
+			}
+		}
+		if len(colors) > 1 {
+			fail("%s: has %d color pics, at most one is allowed", where, len(colors))
+		}
+
+		for _, f := range it.Files {
+			if f.Name == "" {
+				fail("%s: file without a name", where)
+				continue
+			}
+			if other, ok := keys[f.Key()]; ok {
+				fail("%s: file %s is also listed under item %s", where, f.Key(), other)
+			}
+			keys[f.Key()] = it.Code
+
+			switch f.Type {
+			case TypeImage:
+				if f.Sort < 1 {
+					fail("%s: %s has sort %d, must be 1 or more", where, f.Name, f.Sort)
+				}
+				if f.IsDef < 0 {
+					fail("%s: %s has is_def %d, must not be negative", where, f.Name, f.IsDef)
+				}
+				if f.ColorPic != "" && !colors[f.ColorPic] {
+					fail("%s: %s refers to color pic %s, which is not a color file of the item", where, f.Name, f.ColorPic)
+				}
+			case TypeColor:
+				if f.ColorPic != "" {
+					fail("%s: color file %s refers to another color pic", where, f.Name)
+				}
+			default:
+				fail("%s: %s has unknown type %q", where, f.Name, f.Type)
+			}
+
+			for _, r := range f.Renditions {
+				switch {
+				case r.Name != RenditionBig && r.Name != RenditionSmall && r.Name != RenditionOut:
+					fail("%s: %s has unknown rendition %q", where, f.Name, r.Name)
+				case r.Path == "" || path.IsAbs(r.Path) || strings.Contains(r.Path, `\`) || path.Clean(r.Path) != r.Path || strings.HasPrefix(r.Path, "../"):
+					fail("%s: %s rendition %s path %q must be relative to the work path", where, f.Name, r.Name, r.Path)
+				case r.SHA256 != "" && !isSHA256(r.SHA256):
+					fail("%s: %s rendition %s has an invalid sha256", where, f.Name, r.Name)
+				}
+			}
+			if f.Upload != nil && f.Upload.Status == "" {
+				fail("%s: %s upload has no status", where, f.Name)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func isSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+// AddItem appends an item and returns it.
+func (m *Manifest) AddItem(it *Item) *Item {
+	m.Items = append(m.Items, it)
+	return it
+}
+
+// Lookup returns the file with the given key and its item.
+func (m *Manifest) Lookup(key string) (*Item, *File, bool) {
+	for _, it := range m.Items {
+		for _, f := range it.Files {
+			if f.Key() == key {
+				return it, f, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// Key identifies the file within the run: the path of its SMALL rendition,
+// which Compress and Upload work on, or the name when it has none. Files of
+// different items may share a name, their keys are unique.
+func (f *File) Key() string {
+	if r := f.Rendition(RenditionSmall); r != nil {
+		return r.Path
+	}
+	return f.Name
+}
+
+// Rendition returns the rendition called name, or nil.
+func (f *File) Rendition(name string) *Rendition {
+	for i := range f.Renditions {
+		if f.Renditions[i].Name == name {
+			return &f.Renditions[i]
+		}
+	}
+	return nil
+}
+
+// AddRendition records the copy of f at workPath/rel with its size and hash.
+func (f *File) AddRendition(name, workPath, rel string) error {
+	r := Rendition{Name: name, Path: filepath.ToSlash(rel)}
+	if err := r.Update(workPath); err != nil {
+		return err
+	}
+	if existing := f.Rendition(name); existing != nil {
+		*existing = r
+	} else {
+		f.Renditions = append(f.Renditions, r)
+	}
+	return nil
+}
+
+// Update reads the size and hash of the rendition again, e.g. after Compress.
+func (r *Rendition) Update(workPath string) error {
+	file, err := os.Open(filepath.Join(workPath, filepath.FromSlash(r.Path)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	r.Size = n
+	r.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// legacyEntry is one value of a version 1 manifest.
+type legacyEntry struct {
+	ExcelColD         string   `json:"excel_col_d"`
+	FtpPath           string   `json:"ftp_path"`
+	Sort              int      `json:"sort"`
+	IsDef             int      `json:"is_def"`
+	ColorPicFilename  string   `json:"color_pic_filename"`
+	ColorPicGenerated bool     `json:"color_pic_generated"`
+	Type              string   `json:"type"`
+	Folder            string   `json:"folder"`
+	Color             string   `json:"color"`
+	Sheet             string   `json:"sheet"`
+	ExcelRow          int      `json:"excel_row"`
+	SourceImage       string   `json:"source_image"`
+	Warnings          []string `json:"warnings"`
+}
+
+// migrate groups a version 1 manifest by row. Color pics older versions only
+// referenced get a file of their own; the SMALL path is rebuilt from the
+// folder layout of Split.
+func migrate(flat map[string]legacyEntry) *Manifest {
+	m := New("migrated")
+	m.Migrated = true
+
+	names := make([]string, 0, len(flat))
+	for name := range flat {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type rowKey struct {
+		sheet string
+		row   int
+		code  string
+	}
+	items := make(map[rowKey]*Item)
+	for _, name := range names {
+		e := flat[name]
+		key := rowKey{e.Sheet, e.ExcelRow, e.ExcelColD}
+		it, ok := items[key]
+		if !ok {
+			it = m.AddItem(&Item{Code: e.ExcelColD, Sheet: e.Sheet, ExcelRow: e.ExcelRow, Folder: e.Folder, Color: e.Color, Warnings: e.Warnings})
+			items[key] = it
+		}
+		f := &File{Name: name, Type: e.Type, Sort: e.Sort, IsDef: e.IsDef, ColorPic: e.ColorPicFilename, Generated: e.ColorPicGenerated, SourceImage: e.SourceImage}
+		if f.Type == "" {
+			f.Type = TypeImage
+		}
+		if f.Type == TypeColor {
+			f.ColorPic = ""
+		}
+		if e.FtpPath != "" {
+			f.Upload = &Upload{FtpPath: e.FtpPath, Status: "uploaded"}
+		}
+		it.Files = append(it.Files, f)
+	}
+
+	for _, it := range m.Items {
+		have := make(map[string]bool)
+		for _, f := range it.Files {
+			if f.Type == TypeColor {
+				have[f.Name] = true
+			}
+		}
+		for _, f := range it.Files {
+			if f.ColorPic != "" && !have[f.ColorPic] && flat[f.ColorPic].ExcelColD == "" {
+				it.Files = append(it.Files, &File{Name: f.ColorPic, Type: TypeColor, Generated: f.Generated})
+				have[f.ColorPic] = true
+			}
+			if f.Type == TypeImage {
+				f.Generated = false
+			}
+		}
+		for _, f := range it.Files {
+			if it.Folder != "" && it.Color != "" {
+				f.Renditions = []Rendition{{Name: RenditionSmall, Path: path.Join(it.Folder, it.Code+"_"+it.Color, RenditionSmall, f.Name)}}
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,102 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateVersion1(t *testing.T) {
+	data := []byte(`{
+		"ITEM1_01.jpg": {"excel_col_d": "ITEM1", "ftp_path": "/image/2026/ITEM1_01.jpg", "sort": 1, "is_def": 1,
+			"color_pic_filename": "ITEM1_Color.jpg", "folder": "A_B", "color": "Red", "excel_row": 3},
+		"ITEM1_02.jpg": {"excel_col_d": "ITEM1", "sort": 2, "is_def": 0,
+			"color_pic_filename": "ITEM1_Color.jpg", "folder": "A_B", "color": "Red", "excel_row": 3},
+		"ITEM2_01.jpg": {"excel_col_d": "ITEM2", "sort": 1, "is_def": 1, "folder": "A_B", "color": "Blue", "excel_row": 4}
+	}`)
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Migrated || m.Version != Version || len(m.Items) != 2 {
+		t.Fatalf("migrated manifest = %+v", m)
+	}
+
+	it, f, ok := m.Lookup("A_B/ITEM1_Red/SMALL/ITEM1_Color.jpg")
+	if !ok || it.Code != "ITEM1" || f.Type != TypeColor {
+		t.Errorf("color pic of ITEM1 not migrated to a color file: %+v", f)
+	}
+	_, f, _ = m.Lookup("A_B/ITEM1_Red/SMALL/ITEM1_01.jpg")
+	if f.Upload == nil || f.Upload.FtpPath != "/image/2026/ITEM1_01.jpg" || f.Upload.Status != "uploaded" {
+		t.Errorf("upload = %+v", f.Upload)
+	}
+	if r := f.Rendition(RenditionSmall); r == nil || r.Path != "A_B/ITEM1_Red/SMALL/ITEM1_01.jpg" {
+		t.Errorf("SMALL rendition = %+v", r)
+	}
+	if _, f, _ = m.Lookup("A_B/ITEM1_Red/SMALL/ITEM1_02.jpg"); f.Upload != nil {
+		t.Errorf("file without ftp_path has upload %+v", f.Upload)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, want string
+	}{
+		{"version", `{"version": 3, "run_id": "r", "items": []}`, "version 3 is not supported"},
+		{"unknown field", `{"version": 2, "run_id": "r", "items": [], "extra": 1}`, "unknown field"},
+		{"run id", `{"version": 2, "items": []}`, "run_id is missing"},
+		{"duplicate name", `{"version": 2, "run_id": "r", "items": [
+			{"code": "A", "files": [{"name": "x.jpg", "type": "image", "sort": 1, "is_def": 1}]},
+			{"code": "B", "files": [{"name": "x.jpg", "type": "image", "sort": 1, "is_def": 1}]}]}`, "also listed under item A"},
+		{"color pic", `{"version": 2, "run_id": "r", "items": [
+			{"code": "A", "files": [{"name": "x.jpg", "type": "image", "sort": 1, "is_def": 1, "color_pic": "A_Color.jpg"}]}]}`, "not a color file of the item"},
+		{"path", `{"version": 2, "run_id": "r", "items": [
+			{"code": "A", "files": [{"name": "x.jpg", "type": "image", "sort": 1, "is_def": 1,
+				"renditions": [{"name": "SMALL", "path": "../x.jpg", "size": 1}]}]}]}`, "must be relative"},
+		{"type", `{"version": 2, "run_id": "r", "items": [
+			{"code": "A", "files": [{"name": "x.jpg", "type": "video"}]}]}`, "unknown type"},
+	} {
+		_, err := Parse([]byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	work := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(work, "A_B", "SMALL"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(work, "A_B", "SMALL", "x.jpg"), []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New("20261019_120000")
+	it := m.AddItem(&Item{Code: "A", ExcelRow: 1})
+	f := &File{Name: "x.jpg", Type: TypeImage, Sort: 1, IsDef: 1}
+	it.Files = append(it.Files, f)
+	if err := f.AddRendition(RenditionSmall, work, filepath.Join("A_B", "SMALL", "x.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Save(Path(work)); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(Path(work))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got, ok := loaded.Lookup("A_B/SMALL/x.jpg")
+	if !ok {
+		t.Fatal("x.jpg missing after load")
+	}
+	r := got.Rendition(RenditionSmall)
+	if r == nil || r.Path != "A_B/SMALL/x.jpg" || r.Size != 4 || len(r.SHA256) != 64 {
+		t.Errorf("rendition = %+v", r)
+	}
+	if loaded.Migrated || loaded.RunID != "20261019_120000" {
+		t.Errorf("loaded = %+v", loaded)
+	}
+}
@@ -0,0 +1,227 @@
+// Package mockapi is a stand-in for the Laravel /savePicDataFromGo endpoint,
+// used by the mock-api command and by tests to exercise uploads offline.
+package mockapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"ahMakerdir/internal/api"
+)
+
+// Path is the endpoint served by the mock.
+const Path = "/savePicDataFromGo"
+
+// Script controls how the mock answers.
+type Script struct {
+	NotFoundSNs []string      // SNs reported in not_found_sns when they appear in a request
+	FailFirst   int           // Answer the first N requests with HTTP 500
+	RejectSNs   []string      // Answer requests holding one of these SNs with HTTP 422
+	HTTPStatus  int           // Answer every request with this status, 0 for 200
+	Status      string        // Value of "status", default "success"
+	Message     string        // Value of "message"
+	Version     string        // Value of "version", empty to leave it out
+	Delay       time.Duration // Wait before answering, to provoke client timeouts
+	Key         string        // When set, require the key header or a valid signature
+	RecordDir   string        // When set, save each received payload as JSON here
+}
+
+// Request is one call received by the mock.
+type Request struct {
+	Time           time.Time
+	Key            string
+	IdempotencyKey string
+	Timestamp      string
+	Signature      string
+	Payload        api.Payload
+}
+
+// Server is the mock API. It implements http.Handler and can also listen on
+// its own address with Start.
+type Server struct {
+	mu        sync.Mutex
+	script    Script
+	requests  []Request
+	nextPicID int
+	nextColID int
+	listener  net.Listener
+	srv       *http.Server
+	Log       func(string)
+}
+
+// New returns a mock answering according to script.
+func New(script Script) *Server {
+	return &Server{script: script, nextPicID: 1, nextColID: 1}
+}
+
+// SetScript replaces the script for the following requests.
+func (s *Server) SetScript(script Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = script
+}
+
+// Requests returns the calls received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Start listens on addr (e.g. "127.0.0.1:0") and returns the endpoint URL.
+func (s *Server) Start(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	s.listener = l
+	mux := http.NewServeMux()
+	mux.Handle(Path, s)
+	s.srv = &http.Server{Handler: mux}
+	go s.srv.Serve(l)
+	return fmt.Sprintf("http://%s%s", l.Addr().String(), Path), nil
+}
+
+// Close stops a server started with Start.
+func (s *Server) Close() error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Close()
+}
+
+// ServeHTTP handles one API call.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := Request{
+		Time:           time.Now(),
+		Key:            r.Header.Get("key"),
+		IdempotencyKey: r.Header.Get("Idempotency-Key"),
+		Timestamp:      r.Header.Get("X-Timestamp"),
+		Signature:      r.Header.Get("X-Signature"),
+	}
+	if err := json.Unmarshal(body, &req.Payload); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, api.Response{Status: "error", Message: "invalid payload: " + err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	n := len(s.requests)
+	script := s.script
+	s.mu.Unlock()
+
+	s.logf("Received request %d: %d images, idempotency key %s", n, len(req.Payload), req.IdempotencyKey)
+	if script.RecordDir != "" {
+		if err := record(script.RecordDir, n, body); err != nil {
+			s.logf("Failed to record payload: %v", err)
+		}
+	}
+
+	if script.Delay > 0 {
+		time.Sleep(script.Delay)
+	}
+
+	if script.Key != "" && req.Key != script.Key &&
+		(req.Signature == "" || req.Signature != api.Signature(script.Key, req.Timestamp, body)) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "error", "massage": "invalid key"})
+		return
+	}
+	if n <= script.FailFirst {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"status": "error", "massage": "scripted failure"})
+		return
+	}
+	for _, sn := range script.RejectSNs {
+		for _, item := range req.Payload {
+			if item.ExcelColD == sn {
+				writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"status": "error", "massage": "rejected " + sn})
+				return
+			}
+		}
+	}
+	if script.HTTPStatus >= 400 {
+		writeJSON(w, script.HTTPStatus, map[string]string{"status": "error", "massage": script.Message})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.answer(script, req.Payload))
+}
+
+// answer builds a success response: scripted SNs present in the payload are
+// not found, every other image gets a goods_color_pic ID and every other SN a
+// goods_color ID.
+func (s *Server) answer(script Script, payload api.Payload) api.Response {
+	missing := make(map[string]bool)
+	for _, sn := range script.NotFoundSNs {
+		missing[sn] = true
+	}
+
+	filenames := make([]string, 0, len(payload))
+	for filename := range payload {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	resp := api.Response{Version: script.Version, Status: script.Status, Message: script.Message}
+	if resp.Status == "" {
+		resp.Status = "success"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, filename := range filenames {
+		sn := payload[filename].ExcelColD
+		if missing[sn] {
+			if !seen[sn] {
+				resp.NotFoundSNs = append(resp.NotFoundSNs, sn)
+			}
+		} else {
+			resp.SuccessGoodsColorPicIDs = append(resp.SuccessGoodsColorPicIDs, s.nextPicID)
+			s.nextPicID++
+			if !seen[sn] {
+				resp.SuccessGoodsColorIDs = append(resp.SuccessGoodsColorIDs, s.nextColID)
+				s.nextColID++
+			}
+		}
+		seen[sn] = true
+	}
+	return resp
+}
+
+func record(dir string, n int, body []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("request_%03d_%s.json", n, time.Now().Format("20060102_150405"))
+	return os.WriteFile(filepath.Join(dir, name), body, 0644)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Log != nil {
+		s.Log(fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,113 @@
+package mockapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ahMakerdir/internal/api"
+)
+
+const testBody = `{"ITEM1_01.jpg":{"excel_col_d":"ITEM1","ftp_path":"/image/a.jpg","sort":1,"is_def":1},` +
+	`"ITEM1_02.jpg":{"excel_col_d":"ITEM1","ftp_path":"/image/b.jpg","sort":2,"is_def":0},` +
+	`"ITEM2_01.jpg":{"excel_col_d":"ITEM2","ftp_path":"/image/c.jpg","sort":1,"is_def":1}}`
+
+// post sends testBody through ServeHTTP and returns the status and decoded reply.
+func post(t *testing.T, s *Server, header map[string]string) (int, api.Response) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, Path, strings.NewReader(testBody))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	var resp api.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("reply %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestScriptedAnswers(t *testing.T) {
+	s := New(Script{NotFoundSNs: []string{"ITEM2"}, Version: "1"})
+	code, resp := post(t, s, nil)
+	if code != http.StatusOK || resp.Status != "success" || resp.Version != "1" {
+		t.Fatalf("HTTP %d, %+v", code, resp)
+	}
+	if len(resp.NotFoundSNs) != 1 || resp.NotFoundSNs[0] != "ITEM2" {
+		t.Errorf("not_found_sns = %v, want [ITEM2]", resp.NotFoundSNs)
+	}
+	// Two images of ITEM1, one goods_color for it
+	if len(resp.SuccessGoodsColorPicIDs) != 2 || len(resp.SuccessGoodsColorIDs) != 1 {
+		t.Errorf("IDs = %v / %v, want 2 pic IDs and 1 color ID", resp.SuccessGoodsColorPicIDs, resp.SuccessGoodsColorIDs)
+	}
+
+	// IDs keep counting across requests
+	_, resp = post(t, s, nil)
+	if resp.SuccessGoodsColorPicIDs[0] != 3 || resp.SuccessGoodsColorIDs[0] != 2 {
+		t.Errorf("second request IDs = %v / %v, want to start at 3 / 2", resp.SuccessGoodsColorPicIDs, resp.SuccessGoodsColorIDs)
+	}
+}
+
+func TestScriptedFailures(t *testing.T) {
+	for _, tc := range []struct {
+		script  Script
+		codes   []int // Status of consecutive requests
+		message string
+	}{
+		{Script{FailFirst: 2}, []int{500, 500, 200}, "scripted failure"},
+		{Script{HTTPStatus: 503, Message: "maintenance"}, []int{503, 503}, "maintenance"},
+		{Script{RejectSNs: []string{"ITEM2"}}, []int{422}, "rejected ITEM2"},
+		{Script{RejectSNs: []string{"ITEM9"}}, []int{200}, ""},
+	} {
+		s := New(tc.script)
+		for i, want := range tc.codes {
+			code, resp := post(t, s, nil)
+			if code != want {
+				t.Errorf("%+v request %d: HTTP %d, want %d", tc.script, i+1, code, want)
+			}
+			if want != 200 && (resp.Status != "error" || resp.Message != tc.message) {
+				t.Errorf("%+v request %d: %+v, want error %q", tc.script, i+1, resp, tc.message)
+			}
+		}
+		if got := len(s.Requests()); got != len(tc.codes) {
+			t.Errorf("%+v: recorded %d requests, want %d", tc.script, got, len(tc.codes))
+		}
+	}
+}
+
+func TestKeyCheck(t *testing.T) {
+	s := New(Script{Key: "secret"})
+	signature := api.Signature("secret", "1700000000", []byte(testBody))
+	for _, tc := range []struct {
+		header map[string]string
+		code   int
+	}{
+		{nil, http.StatusUnauthorized},
+		{map[string]string{"key": "wrong"}, http.StatusUnauthorized},
+		{map[string]string{"key": "secret"}, http.StatusOK},
+		{map[string]string{"X-Timestamp": "1700000000", "X-Signature": signature}, http.StatusOK},
+		{map[string]string{"X-Timestamp": "1700000001", "X-Signature": signature}, http.StatusUnauthorized},
+	} {
+		if code, resp := post(t, s, tc.header); code != tc.code {
+			t.Errorf("header %v: HTTP %d (%+v), want %d", tc.header, code, resp, tc.code)
+		}
+	}
+}
+
+func TestRecordDir(t *testing.T) {
+	dir := t.TempDir()
+	s := New(Script{RecordDir: dir})
+	post(t, s, map[string]string{"Idempotency-Key": "abc"})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), "request_001_") {
+		t.Fatalf("recorded %v, %v", entries, err)
+	}
+	if r := s.Requests()[0]; r.IdempotencyKey != "abc" || len(r.Payload) != 3 {
+		t.Errorf("request = %+v", r)
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"os"
+
+	"ahMakerdir/internal/cli"
 	"ahMakerdir/internal/gui"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
 	gui.RunApp()
 }